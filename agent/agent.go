@@ -2,11 +2,11 @@ package agent
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"pprlgoFrozenLake/doublenc"
 	"pprlgoFrozenLake/environment"
 	"pprlgoFrozenLake/party"
-	"pprlgoFrozenLake/position"
 	"pprlgoFrozenLake/pprl"
 	"pprlgoFrozenLake/utils"
 
@@ -14,15 +14,30 @@ import (
 )
 
 type Agent struct {
-	actionNum  int
-	stateNum   int
-	lakeHeight int
-	lakeWidth  int
-	InitValQ   float64
-	Epsilon    float64
-	Alpha      float64
-	Gamma      float64
-	Qtable     [][]float64 // Qテーブルの状態は1次元とする (状態をposition.Positionにすると暗号化時に処理できない)
+	actionNum int
+	stateNum  int
+	InitValQ  float64
+	Epsilon   float64
+	Gamma     float64
+	Qtable    [][]float64 // Qテーブルの状態は環境が返す1次元の状態IDとする (状態をposition.Positionにすると暗号化時に処理できない)
+	Learner   Learner     // 目標値を求める学習アルゴリズム (デフォルトはQ学習)
+
+	LearningRate LearningRate // 訪問回数に応じた学習率 (デフォルトはALPHAで一定)
+	visits       [][]int      // 実際の遷移で各(状態, 行動)を訪れた回数 N(s,a) (試行ごとに0に戻す)
+
+	QtableB [][]float64 // Double Q学習の2つ目のQテーブル (Qtableを1つ目とする．それ以外の学習アルゴリズムではnil)
+
+	Exploration ExplorationPolicy // 行動選択の探索方策 (デフォルトはEpsilonのε-greedy)
+
+	lastEpsilonDraw float64 // 直前の行動選択で探索方策が使用した乱数 (軌跡の記録で使用する．使用しなかった場合は0)
+	updatingB       bool    // Double Q学習で現在更新しているのがQtableBかどうか
+
+	UpdateRequests       int        // クラウドのQテーブルを更新した要求の回数 (通信パターンの分析に使用する．全試行の累計)
+	UpdatedEntries       int        // 更新を要求したQテーブルの要素数の累計
+	ClampedEntries       int        // 暗号化できる範囲を超えて飽和させたQ値の数の累計 (0でなければクラウドのQテーブルはエージェントと一致しない)
+	SelectionRequests    int        // SecureSelectActions()でクラウドに行動価値をまとめて問い合わせた要求の回数の累計
+	SelectionCiphertexts int        // まとめた問い合わせで受け取った(問い合わせを詰めた)行動価値の暗号文の数の累計
+	rng                  *rand.Rand // 行動選択で使用する乱数生成器 (エージェントごとに独立させて再現性を保つ)
 }
 
 const (
@@ -32,11 +47,9 @@ const (
 	GAMMA         = 0.9
 )
 
-func NewAgent(env *environment.Environment) *Agent {
-	actionNum := len(env.ActionSpace)
-	stateNum := env.Height() * env.Width()
-	lakeHeight := env.Height()
-	lakeWidth := env.Width()
+func NewAgent(env environment.Env) *Agent {
+	actionNum := env.ActionSpace()
+	stateNum := env.ObservationSpace()
 
 	// Qtable[stateNum][actionNum]の二次元配列を作成してInitValQで初期化
 	Qtable := make([][]float64, stateNum)
@@ -48,19 +61,35 @@ func NewAgent(env *environment.Environment) *Agent {
 	}
 
 	return &Agent{
-		actionNum:  actionNum,
-		stateNum:   stateNum,
-		lakeHeight: lakeHeight,
-		lakeWidth:  lakeWidth,
-		InitValQ:   INITIAL_VAL_Q,
-		Epsilon:    EPSILON,
-		Alpha:      ALPHA,
-		Gamma:      GAMMA,
-		Qtable:     Qtable,
+		actionNum:    actionNum,
+		stateNum:     stateNum,
+		InitValQ:     INITIAL_VAL_Q,
+		Epsilon:      EPSILON,
+		LearningRate: DefaultLearningRate(),
+		visits:       newVisits(stateNum, actionNum),
+		Gamma:        GAMMA,
+		Qtable:       Qtable,
+		Learner:      QLearning{},
+		Exploration:  NewEpsilonGreedy(),
+		rng:          rand.New(rand.NewSource(0)),
 	}
 }
 
-func (a *Agent) QtableReset(env *environment.Environment) {
+// 全ての訪問回数が0の N(s,a) を作成
+func newVisits(stateNum int, actionNum int) [][]int {
+	visits := make([][]int, stateNum)
+	for i := range visits {
+		visits[i] = make([]int, actionNum)
+	}
+	return visits
+}
+
+// 行動選択で使用する乱数のシードを設定
+func (a *Agent) Seed(seed int64) {
+	a.rng = rand.New(rand.NewSource(seed))
+}
+
+func (a *Agent) QtableReset() {
 	// Qtable[stateNum][actionNum]の二次元配列を作成してInitValQで初期化
 	for i := range a.Qtable {
 		a.Qtable[i] = make([]float64, a.actionNum)
@@ -68,28 +97,102 @@ func (a *Agent) QtableReset(env *environment.Environment) {
 			a.Qtable[i][j] = INITIAL_VAL_Q
 		}
 	}
+
+	a.visits = newVisits(a.stateNum, a.actionNum)
+
+	// 探索方策のスケジュールと訪問回数を初期化する
+	a.Exploration.Reset(a)
+
+	// 学習器がエピソードをまたぐ状態を持つ場合は初期化する
+	if resetter, ok := a.Learner.(interface{ Reset() }); ok {
+		resetter.Reset()
+	}
+
+	// Double Q学習では2つ目のQテーブルも同様に初期化する
+	a.QtableB = nil
+	if a.IsDouble() {
+		a.QtableB = make([][]float64, a.stateNum)
+		for i := range a.QtableB {
+			a.QtableB[i] = make([]float64, a.actionNum)
+			for j := range a.QtableB[i] {
+				a.QtableB[i][j] = INITIAL_VAL_Q
+			}
+		}
+	}
 }
 
-func (e *Agent) Learn(state position.Position, act int, rwd int, next_state position.Position, keyTools party.BfvKeyTools, encryptedQtable []*rlwe.Ciphertext) {
-	state_1D := e.convert2DTo1D(state)
-	next_state_1D := e.convert2DTo1D(next_state)
+// 学習アルゴリズム(Learner)の目標値でQ値を更新し，クラウドのQテーブルにも反映する
+// t.Terminatedがtrueの場合は次の状態が終了状態なので，次の状態のQ値でブートストラップしない
+// 最大ステップ数による打ち切り(truncated)の場合は終了状態ではないため，通常通りブートストラップする
+// Double Q学習では，QtableとQtableBのどちらを更新するかを等確率で選び，クラウドの対応するQテーブルに反映する
+// 1ステップで複数のQ値を更新する学習アルゴリズム(BatchLearner)では，更新した全てのQ値を1回の要求で反映する
+func (e *Agent) Learn(t Transition, keyTools party.BfvKeyTools, encryptedQtable []*rlwe.Ciphertext) {
+	e.visit(t.State, t.Action)
+
+	if batch, ok := e.Learner.(BatchLearner); ok {
+		e.pushUpdates(batch.Update(e, t), keyTools, encryptedQtable)
+		return
+	}
+	if multi, ok := e.Learner.(MultiBatchLearner); ok {
+		for _, updates := range multi.Updates(e, t) {
+			e.pushUpdates(updates, keyTools, encryptedQtable)
+		}
+		return
+	}
+
+	state_1D, act := t.State, t.Action
 
-	target := float64(0)
-	target = float64(rwd) + e.Gamma*e.maxValue(e.Qtable[next_state_1D]) // rwdは整数値なので実数値にキャストする
+	qtable := e.Qtable
+	if e.IsDouble() {
+		e.updatingB = e.rng.Float64() < 0.5
+		qtableA, qtableB := e.splitEncryptedQtable(encryptedQtable)
+		encryptedQtable = qtableA
+		if e.updatingB {
+			qtable = e.QtableB
+			encryptedQtable = qtableB
+		}
+	}
 
-	e.Qtable[state_1D][act] = (1-e.Alpha)*e.Qtable[state_1D][act] + e.Alpha*target
+	target := e.Learner.Target(e, t)
+	alpha := e.alpha(state_1D, act)
+	qtable[state_1D][act] = (1-alpha)*qtable[state_1D][act] + alpha*target
 
 	v_t := make([]uint64, e.stateNum)
 	w_t := make([]uint64, e.actionNum)
 	v_t[state_1D] = 1
 	w_t[act] = 1
 
-	Qnew := e.Qtable[state_1D][act]
-	Qnew_int := int64(Qnew * utils.Q_int_coeff)
-	Q_new_uint64 := utils.MapInteger(int64(Qnew_int))
+	Qnew := qtable[state_1D][act]
+	Q_new_uint64 := e.encodeQ(Qnew)
+	e.UpdateRequests++
+	e.UpdatedEntries++
 	pprl.SecureQtableUpdatingWithBFV(keyTools.Params, keyTools.Encoder, keyTools.Encryptor, keyTools.Decryptor, keyTools.Evaluator, keyTools.PublicKey, keyTools.PrivateKey, v_t, w_t, Q_new_uint64, e.stateNum, e.actionNum, encryptedQtable)
 }
 
+// 更新したQ値をまとめて1回の要求でクラウドのQテーブルに反映する
+func (e *Agent) pushUpdates(updates []QUpdate, keyTools party.BfvKeyTools, encryptedQtable []*rlwe.Ciphertext) {
+	if len(updates) == 0 {
+		return
+	}
+
+	Q_updates := make([]pprl.QtableUpdate, len(updates))
+	for i, update := range updates {
+		Q_updates[i] = pprl.QtableUpdate{State: update.State, Action: update.Action, Q_new: e.encodeQ(update.Qnew)}
+	}
+	e.UpdateRequests++
+	e.UpdatedEntries += len(updates)
+	pprl.SecureBatchQtableUpdatingWithBFV(keyTools.Params, keyTools.Encoder, keyTools.Encryptor, keyTools.Decryptor, keyTools.Evaluator, keyTools.PublicKey, keyTools.PrivateKey, Q_updates, e.stateNum, e.actionNum, encryptedQtable)
+}
+
+// クラウドのQテーブルに送るQ値を固定小数点表現に変換し，範囲を超えて飽和させた場合は数える
+func (e *Agent) encodeQ(Qnew float64) uint64 {
+	encoded, clamped := utils.EncodeQ(Qnew, e.SummedQtables())
+	if clamped {
+		e.ClampedEntries++
+	}
+	return encoded
+}
+
 func (e *Agent) maxValue(slice []float64) float64 {
 	maxValue := slice[0]
 	for _, v := range slice {
@@ -100,54 +203,104 @@ func (e *Agent) maxValue(slice []float64) float64 {
 	return maxValue
 }
 
-// Qテーブルの状態(1次元)に格納するため，二次元座標を一次元インデックスに変換
-func (e *Agent) convert2DTo1D(state position.Position) int {
-	return state.Y*e.lakeWidth + state.X
-}
-
 // ランダムに行動を選択
 func (a *Agent) ChooseRandomAction() int {
-	return rand.Intn(a.actionNum) // 0からactionNum-1までの範囲でランダムに整数を返す
+	return a.rng.Intn(a.actionNum) // 0からactionNum-1までの範囲でランダムに整数を返す
 }
 
-// εグリーディー方策
-func (a *Agent) EpsilonGreedyAction(state position.Position) int {
-	state_1D := a.convert2DTo1D(state)
+// 探索方策に従って行動を選択 (エージェントの平文のQテーブルから選択)
+func (a *Agent) SelectAction(state_1D int) int {
+	a.lastEpsilonDraw = 0
+	if action := a.Exploration.Explore(a, state_1D); action >= 0 {
+		return action
+	}
+	return a.Exploration.Choose(a, state_1D, a.PolicyQtable()[state_1D])
+}
 
-	// εより小さいランダムな値を生成してランダムに行動を選択
-	if rand.Float64() < a.Epsilon {
-		return a.ChooseRandomAction()
+// 探索方策に従って行動を選択 (クラウド上のQテーブルから選択)
+// 探索方策が行動価値を使わずに行動を決めた場合は，クラウドに問い合わせない
+func (a *Agent) SecureSelectAction(state_1D int, keyTools party.BfvKeyTools, encryptedQtable []*rlwe.Ciphertext) int {
+	a.lastEpsilonDraw = 0
+	if action := a.Exploration.Explore(a, state_1D); action >= 0 {
+		return action
 	}
 
-	// 最大のQ値を持つ行動を選択
-	maxAction := 0
-	maxQValue := a.Qtable[state_1D][0]
-	for action, qValue := range a.Qtable[state_1D] {
-		if qValue > maxQValue {
-			maxAction = action
-			maxQValue = qValue
-		}
+	v_t := make([]float64, a.stateNum)
+	v_t[state_1D] = 1
+
+	// 状態の行動価値を取得 (Double Q学習では2つのQテーブルの和)
+	// actions_Q_in_state := pprl.SecureActionSelection(v_t, a.stateNum, a.actionNum, testContext, encryptedQtable, user_list)
+	var actions_Q_in_state *rlwe.Ciphertext
+	if a.IsDouble() {
+		qtableA, qtableB := a.splitEncryptedQtable(encryptedQtable)
+		actions_Q_in_state = pprl.SecureSumActionSelectionWithBFV(keyTools.Params, keyTools.Encoder, keyTools.Encryptor, keyTools.Decryptor, keyTools.Evaluator, keyTools.PublicKey, keyTools.PrivateKey, v_t, a.stateNum, a.actionNum, qtableA, qtableB)
+	} else {
+		actions_Q_in_state = pprl.SecureActionSelectionWithBFV(keyTools.Params, keyTools.Encoder, keyTools.Encryptor, keyTools.Decryptor, keyTools.Evaluator, keyTools.PublicKey, keyTools.PrivateKey, v_t, a.stateNum, a.actionNum, encryptedQtable)
 	}
 
-	return maxAction
+	return a.Exploration.Choose(a, state_1D, a.decryptActionValues(keyTools, actions_Q_in_state))
 }
 
-// εグリーディー方策(クラウド上のQテーブルから選択)
-func (a *Agent) SecureEpsilonGreedyAction(state position.Position, keyTools party.BfvKeyTools, encryptedQtable []*rlwe.Ciphertext) int {
-	// εより小さいランダムな値を生成してランダムに行動を選択
-	if rand.Float64() < a.Epsilon {
-		return a.ChooseRandomAction()
+// 探索方策に従った行動選択(クラウド上のQテーブルから選択)を複数の状態に対して同時に行う
+// ベクトル化環境の各コピーの状態のうち，行動価値が必要な状態だけを1回の要求でまとめて問い合わせる
+// 問い合わせは暗号文のスロットに詰めるので，二重暗号化して送るマスクは詰めた暗号文1つにつき状態数分で済む
+func (a *Agent) SecureSelectActions(states_1D []int, keyTools party.BfvKeyTools, encryptedQtable []*rlwe.Ciphertext) []int {
+	actions := make([]int, len(states_1D))
+
+	// 探索方策が行動価値を使わずに行動を決めた状態以外を問い合わせる
+	v_ts := [][]float64{}
+	greedy_indices := []int{}
+	for i, state_1D := range states_1D {
+		if action := a.Exploration.Explore(a, state_1D); action >= 0 {
+			actions[i] = action
+			continue
+		}
+
+		v_t := make([]float64, a.stateNum)
+		v_t[state_1D] = 1
+		v_ts = append(v_ts, v_t)
+		greedy_indices = append(greedy_indices, i)
 	}
 
-	state_1D := a.convert2DTo1D(state)
-	v_t := make([]float64, a.stateNum)
-	v_t[state_1D] = 1
+	if len(v_ts) == 0 {
+		return actions
+	}
 
-	// 最大のQ値を持つ行動を選択
-	// actions_Q_in_state := pprl.SecureActionSelection(v_t, a.stateNum, a.actionNum, testContext, encryptedQtable, user_list)
-	actions_Q_in_state := pprl.SecureActionSelectionWithBFV(keyTools.Params, keyTools.Encoder, keyTools.Encryptor, keyTools.Decryptor, keyTools.Evaluator, keyTools.PublicKey, keyTools.PrivateKey, v_t, a.stateNum, a.actionNum, encryptedQtable)
+	// Double Q学習では，同じマスクで2つのQテーブルの和を求める
+	qtables := [][]*rlwe.Ciphertext{encryptedQtable}
+	if a.IsDouble() {
+		qtableA, qtableB := a.splitEncryptedQtable(encryptedQtable)
+		qtables = [][]*rlwe.Ciphertext{qtableA, qtableB}
+	}
+	packed := pprl.SecureActionSelectionsWithBFV(keyTools.Params, keyTools.Encoder, keyTools.Encryptor, keyTools.Decryptor, keyTools.Evaluator, keyTools.PublicKey, keyTools.PrivateKey, v_ts, a.stateNum, a.actionNum, qtables...)
+	a.SelectionRequests++
+	a.SelectionCiphertexts += len(packed)
+
+	packed_msgs := make([][]uint64, len(packed))
+	for c, ciphertext := range packed {
+		packed_msgs[c] = doublenc.BFVdec(keyTools.Params, keyTools.Encoder, keyTools.Decryptor, ciphertext)
+	}
+	for k, i := range greedy_indices {
+		c, slot := pprl.SelectionQuerySlot(keyTools.Params, a.actionNum, k)
+		actions[i] = a.Exploration.Choose(a, states_1D[i], a.decodeActionValues(packed_msgs[c][slot:slot+a.actionNum]))
+	}
+
+	return actions
+}
+
+// エピソードの終了を探索方策に伝え，εや温度のスケジュールを進める
+func (a *Agent) EndEpisode() {
+	a.Exploration.EndEpisode(a)
+}
+
+// 秘匿計算で得た行動価値の暗号文を復号し，実数値に戻す
+func (a *Agent) decryptActionValues(keyTools party.BfvKeyTools, actions_Q_in_state *rlwe.Ciphertext) []float64 {
 	actions_Q_in_state_msg := doublenc.BFVdec(keyTools.Params, keyTools.Encoder, keyTools.Decryptor, actions_Q_in_state)
+	return a.decodeActionValues(actions_Q_in_state_msg[:a.actionNum])
+}
 
+// 復号した行動価値を固定小数点表現から実数値に戻す
+func (a *Agent) decodeActionValues(actions_Q_in_state_msg []uint64) []float64 {
 	actions_Q_in_state_float64 := make([]float64, a.actionNum)
 
 	// [0, 2N] -> [-N, N] +  係数の除去
@@ -156,30 +309,40 @@ func (a *Agent) SecureEpsilonGreedyAction(state position.Position, keyTools part
 		actions_Q_in_state_float64[idx] = float64(Q_new_int64) / utils.Q_int_coeff
 	}
 
-	maxAction := 0
-	maxQValue := actions_Q_in_state_float64[0]
+	return actions_Q_in_state_float64
+}
 
-	for idx := 0; idx < a.actionNum; idx++ {
-		qValue := actions_Q_in_state_float64[idx]
-		if qValue > maxQValue {
-			maxAction = idx
-			maxQValue = qValue
+// 行動選択で最大のQ値を持つ行動 (同じ値の行動が複数ある場合はエージェントの乱数で等確率に選ぶ)
+// Q値はクラウドのQテーブルと同じくQ_int_coeffの固定小数点に丸めてから比較するので，
+// 平文のQテーブルと復号したQテーブルのどちらから選んでも同じ行動が同点として扱われる
+func (a *Agent) greedyAction(actions_Q []float64) int {
+	ties := []int{}
+	maxQValue := int64(0)
+	for action, qValue := range actions_Q {
+		Q_int := int64(math.Round(qValue * utils.Q_int_coeff))
+		if len(ties) == 0 || Q_int > maxQValue {
+			ties = []int{action}
+			maxQValue = Q_int
+		} else if Q_int == maxQValue {
+			ties = append(ties, action)
 		}
 	}
 
-	return maxAction
+	if len(ties) == 1 {
+		return ties[0]
+	}
+	return ties[a.rng.Intn(len(ties))]
 }
 
-// 貪欲方策
-func (a *Agent) GreedyAction(state position.Position) int {
-	state_1D := a.convert2DTo1D(state)
-
-	// 最大のQ値を持つ行動を選択
+// 最大のQ値を持つ行動 (同じ値の場合はインデックスが小さい行動．乱数を使わないので目標値の計算で使用する)
+func (a *Agent) maxAction(actions_Q []float64) int {
 	maxAction := 0
-	maxQValue := a.Qtable[state_1D][0]
-	for action, qValue := range a.Qtable[state_1D] {
+	maxQValue := actions_Q[0]
+
+	for idx := 0; idx < a.actionNum; idx++ {
+		qValue := actions_Q[idx]
 		if qValue > maxQValue {
-			maxAction = action
+			maxAction = idx
 			maxQValue = qValue
 		}
 	}
@@ -187,62 +350,57 @@ func (a *Agent) GreedyAction(state position.Position) int {
 	return maxAction
 }
 
-func (a *Agent) ShowQTable() {
-	// 行動インデックスに対応する方向の文字列
-	actionSymbols := map[int]string{
-		0: "↑",
-		1: "↓",
-		2: "←",
-		3: "→",
-	}
+// 貪欲方策
+func (a *Agent) GreedyAction(state_1D int) int {
+	// 最大のQ値を持つ行動を選択
+	return a.greedyAction(a.PolicyQtable()[state_1D])
+}
 
+func (a *Agent) ShowQTable(env environment.Env) {
 	fmt.Println("Qtable:")
 
 	for stateIndex, actions := range a.Qtable {
-		// 状態を二次元座標に変換して表示
-		stateY := stateIndex / a.lakeWidth
-		stateX := stateIndex % a.lakeWidth
-		fmt.Printf("State [Y: %d, X: %d]: ", stateY, stateX)
+		// 状態を環境に応じた表示形式 (グリッドなら二次元座標) に変換して表示
+		fmt.Printf("State %s: ", environment.StateLabel(env, stateIndex))
 
 		for actionIndex, qValue := range actions {
-			// actionIndex を方向の文字列に変換して表示
-			actionSymbol := actionSymbols[actionIndex]
-			fmt.Printf("%s: %.2f ", actionSymbol, qValue)
+			// actionIndex を環境が定める行動の記号に変換して表示
+			fmt.Printf("%s: %.2f ", environment.ActionLabel(env, actionIndex), qValue)
 		}
 		fmt.Println()
 	}
 }
 
-func (a *Agent) ShowOptimalPath(env *environment.Environment) {
+func (a *Agent) ShowOptimalPath(env environment.Env) {
 	currentState := env.Reset() // 環境をリセットしてスタート位置を取得
 	fmt.Println("Optimal Path: ")
+	fmt.Println("START")
 
-	// 行動インデックスに対応する方向の文字列
-	actionSymbols := map[int]string{
-		0: "↑",
-		1: "↓",
-		2: "←",
-		3: "→",
-	}
-
-	for {
+	// 方策がループしている場合に備えて，状態数を超えるステップは表示しない
+	for step := 0; step < a.stateNum; step++ {
 		action := a.GreedyAction(currentState)
 
-		// 最適な行動に基づいて次の状態を決定
-		nextState := env.NextState(currentState, action)
+		// 最適な行動に基づいて次の状態に遷移
+		nextState, _, terminated, truncated, info := env.Step(action)
 
 		// 経路を出力
-		if currentState == env.StartPos {
-			fmt.Println("START")
-		}
-		fmt.Printf("state: %s,  action: %s\n", currentState, actionSymbols[action])
+		fmt.Printf("state: %s,  action: %s\n", environment.StateLabel(env, currentState), environment.ActionLabel(env, action))
 		currentState = nextState
 
-		if currentState == env.GoalPos {
+		if info[environment.INFO_GOAL] == true {
 			fmt.Println("GOAL")
-			break // ゴールに到達したらループを終了
+			return // ゴールに到達したらループを終了
+		}
+		if terminated || truncated {
+			break
 		}
 	}
+	fmt.Println("FAILED")
+}
+
+// 直前の行動選択で探索方策が使用した乱数 (ε-greedyでは探索するかの判定に使用した乱数)
+func (a *Agent) LastEpsilonDraw() float64 {
+	return a.lastEpsilonDraw
 }
 
 func (e *Agent) GetActionNum() int {
@@ -0,0 +1,235 @@
+package agent
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"math"
+	"pprlgoFrozenLake/doublenc"
+	"pprlgoFrozenLake/environment"
+	"pprlgoFrozenLake/frozenlake"
+	"pprlgoFrozenLake/party"
+	"pprlgoFrozenLake/pprl"
+	"pprlgoFrozenLake/utils"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v4/bfv"
+	"github.com/tuneinsight/lattigo/v4/rlwe"
+)
+
+func newTestAgent(t *testing.T, lake frozenlake.FrozenLake) *Agent {
+	t.Helper()
+	env := environment.NewEnvironment(lake, environment.DefaultRewardConfig)
+	agt := NewAgent(env)
+	agt.Seed(1)
+	return agt
+}
+
+// Q値を固定小数点表現に変換する (クラウドのQテーブルに格納する値)
+func encodeTestQ(Q float64) uint64 {
+	return utils.MapInteger(int64(math.Round(Q * utils.Q_int_coeff)))
+}
+
+// クラウドのQテーブルを復号したときと同じ値 (固定小数点表現を経由した値)
+func decodeQ(Q float64) float64 {
+	return float64(utils.UnmapInteger(encodeTestQ(Q))) / utils.Q_int_coeff
+}
+
+// main.goと同じパラメータのBFVの鍵と，二重暗号化に使うRSAの鍵 (actionNumは問い合わせを詰めるための回転鍵に使用する)
+func newTestKeyTools(t *testing.T, actionNum int) party.BfvKeyTools {
+	t.Helper()
+	params, err := bfv.NewParametersFromLiteral(utils.FAST_BUT_NOT_128_SECURITY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kgen := bfv.NewKeyGenerator(params)
+	sk, pk := kgen.GenKeyPair()
+	rlk := kgen.GenRelinearizationKey(sk, 1)
+	rtks := kgen.GenRotationKeysForRotations(pprl.SelectionRotations(params, actionNum), true, sk)
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return party.BfvKeyTools{
+		Params:     params,
+		Encryptor:  bfv.NewEncryptor(params, pk),
+		Decryptor:  bfv.NewDecryptor(params, sk),
+		Encoder:    bfv.NewEncoder(params),
+		Evaluator:  bfv.NewEvaluator(params, rlwe.EvaluationKey{Rlk: rlk, Rtks: rtks}),
+		PrivateKey: privateKey,
+		PublicKey:  &privateKey.PublicKey,
+	}
+}
+
+// 平文のQテーブルの各行を暗号化したクラウドのQテーブル
+func encryptTestQtable(keyTools party.BfvKeyTools, qtable [][]float64) []*rlwe.Ciphertext {
+	encryptedQtable := make([]*rlwe.Ciphertext, len(qtable))
+	for i, row := range qtable {
+		plaintext := make([]uint64, len(row))
+		for j, Q := range row {
+			plaintext[j] = encodeTestQ(Q)
+		}
+		encryptedQtable[i] = doublenc.BFVenc(keyTools.Params, keyTools.Encoder, keyTools.Encryptor, plaintext)
+	}
+	return encryptedQtable
+}
+
+// greedyActionをdraws回呼び出したときの各行動の選択回数
+func greedyHistogram(agt *Agent, values []float64, draws int) []int {
+	counts := make([]int, len(values))
+	for i := 0; i < draws; i++ {
+		counts[agt.greedyAction(values)]++
+	}
+	return counts
+}
+
+// 同点の行動はそれぞれ等確率 (draws/len(ties)) で選ばれ，それ以外の行動は選ばれないことを検査
+func checkUniformTies(t *testing.T, counts []int, ties []int, draws int) {
+	t.Helper()
+	expected := float64(draws) / float64(len(ties))
+	isTie := map[int]bool{}
+	for _, action := range ties {
+		isTie[action] = true
+	}
+
+	for action, count := range counts {
+		if !isTie[action] {
+			if count != 0 {
+				t.Errorf("action %d is not a tie but was chosen %d times: %v", action, count, counts)
+			}
+			continue
+		}
+		// 二項分布の標準偏差の5倍を許容する
+		tolerance := 5 * math.Sqrt(expected*(1-1/float64(len(ties))))
+		if math.Abs(float64(count)-expected) > tolerance {
+			t.Errorf("action %d was chosen %d times, expected %.0f ± %.0f: %v", action, count, expected, tolerance, counts)
+		}
+	}
+}
+
+func TestGreedyActionUniformOnZeroRow(t *testing.T) {
+	agt := newTestAgent(t, frozenlake.FrozenLake4x4)
+	draws := 40000
+
+	counts := greedyHistogram(agt, make([]float64, agt.GetActionNum()), draws)
+	checkUniformTies(t, counts, []int{0, 1, 2, 3}, draws)
+}
+
+func TestGreedyActionUniformOnDecodedRow(t *testing.T) {
+	agt := newTestAgent(t, frozenlake.FrozenLake4x4)
+	draws := 40000
+
+	// 平文のQ値と，それをクラウドで暗号化・復号したQ値で同じ行動が同点になる
+	plaintext := []float64{-16.38, 2.5 - 1e-12, -3.2, 2.5}
+	decoded := make([]float64, len(plaintext))
+	for action, Q := range plaintext {
+		decoded[action] = decodeQ(Q)
+	}
+
+	checkUniformTies(t, greedyHistogram(agt, plaintext, draws), []int{1, 3}, draws)
+	checkUniformTies(t, greedyHistogram(agt, decoded, draws), []int{1, 3}, draws)
+}
+
+func TestGreedyActionKeepsDecodedOrder(t *testing.T) {
+	agt := newTestAgent(t, frozenlake.FrozenLake4x4)
+
+	// 復号したQ値は固定小数点に丸め直しても元の整数に戻るので，1だけ大きい値が常に選ばれる
+	for k := -utils.N; k < utils.N; k++ {
+		lower := float64(utils.UnmapInteger(utils.MapInteger(int64(k)))) / utils.Q_int_coeff
+		upper := float64(utils.UnmapInteger(utils.MapInteger(int64(k+1)))) / utils.Q_int_coeff
+		if action := agt.greedyAction([]float64{lower, upper}); action != 1 {
+			t.Fatalf("greedyAction([%v, %v]) = %d, expected 1", lower, upper, action)
+		}
+	}
+}
+
+func TestSecureSelectActionUniformOnTiedRow(t *testing.T) {
+	// 問い合わせごとに全ての状態のマスクを二重暗号化するので，小さな湖で試す
+	agt := newTestAgent(t, frozenlake.FrozenLake3x3)
+	agt.Epsilon = 0 // 常にクラウドに問い合わせて貪欲に選ぶ
+	keyTools := newTestKeyTools(t, agt.GetActionNum())
+	draws := 150
+
+	// 暗号化した行を秘匿計算で取り出して復号しても，同点の行動はそれぞれ等確率で選ばれる
+	state := 4
+	qtable := make([][]float64, agt.GetStateNum())
+	for i := range qtable {
+		qtable[i] = make([]float64, agt.GetActionNum())
+	}
+	qtable[state] = []float64{1.5, -2.25, 1.5, 1.5}
+	encryptedQtable := encryptTestQtable(keyTools, qtable)
+
+	counts := make([]int, agt.GetActionNum())
+	for i := 0; i < draws; i++ {
+		counts[agt.SecureSelectAction(state, keyTools, encryptedQtable)]++
+	}
+	checkUniformTies(t, counts, []int{0, 2, 3}, draws)
+}
+
+func TestSecureSelectActionsPacksQueriesIntoOneRequest(t *testing.T) {
+	for _, learner := range []Learner{QLearning{}, DoubleQLearning{}} {
+		t.Run(learner.Name(), func(t *testing.T) {
+			agt := newTestAgent(t, frozenlake.FrozenLake3x3)
+			agt.Learner = learner
+			agt.QtableReset()
+			agt.Epsilon = 0
+			keyTools := newTestKeyTools(t, agt.GetActionNum())
+
+			// 状態ごとに最大の行動が異なるQテーブル (Double Q学習では2つのテーブルの和で最大になる)
+			for state := range agt.Qtable {
+				for action := range agt.Qtable[state] {
+					agt.Qtable[state][action] = float64(state) - float64(action)
+					if action == state%agt.GetActionNum() {
+						agt.Qtable[state][action] += 5.5
+					}
+					if agt.IsDouble() {
+						agt.QtableB[state][action] = agt.Qtable[state][action] / 2
+					}
+				}
+			}
+			encryptedQtable := encryptTestQtable(keyTools, agt.CloudLayoutQtable())
+
+			// 1つの暗号文に詰められる数より多い問い合わせは，同じ要求の中で複数の暗号文に分ける
+			states := []int{0, 5, 2, 7, 3, 8}
+			perCiphertext := pprl.SelectionQueriesPerCiphertext(keyTools.Params, agt.GetActionNum())
+			actions := agt.SecureSelectActions(states, keyTools, encryptedQtable)
+
+			for k, state := range states {
+				if expected := state % agt.GetActionNum(); actions[k] != expected {
+					t.Errorf("query %d (state %d): action %d, expected %d", k, state, actions[k], expected)
+				}
+			}
+			// 詰めた各問い合わせのスロットから，その状態の行動価値がそのまま復号できる
+			v_ts := make([][]float64, len(states))
+			for k, state := range states {
+				v_ts[k] = make([]float64, agt.GetStateNum())
+				v_ts[k][state] = 1
+			}
+			qtables := [][]*rlwe.Ciphertext{encryptedQtable}
+			if agt.IsDouble() {
+				qtables = [][]*rlwe.Ciphertext{encryptedQtable[:agt.GetStateNum()], encryptedQtable[agt.GetStateNum():]}
+			}
+			packed := pprl.SecureActionSelectionsWithBFV(keyTools.Params, keyTools.Encoder, keyTools.Encryptor, keyTools.Decryptor, keyTools.Evaluator, keyTools.PublicKey, keyTools.PrivateKey, v_ts, agt.GetStateNum(), agt.GetActionNum(), qtables...)
+			for k, state := range states {
+				c, slot := pprl.SelectionQuerySlot(keyTools.Params, agt.GetActionNum(), k)
+				values := agt.decodeActionValues(doublenc.BFVdec(keyTools.Params, keyTools.Encoder, keyTools.Decryptor, packed[c])[slot:])
+				for action, value := range values {
+					expected := decodeQ(agt.Qtable[state][action])
+					if agt.IsDouble() {
+						expected += decodeQ(agt.QtableB[state][action])
+					}
+					if math.Abs(value-expected) > 1e-9 {
+						t.Errorf("query %d (state %d), action %d: decrypted %v, expected %v", k, state, action, value, expected)
+					}
+				}
+			}
+
+			if agt.SelectionRequests != 1 {
+				t.Errorf("%d selection requests, expected 1", agt.SelectionRequests)
+			}
+			if expected := (len(states) + perCiphertext - 1) / perCiphertext; agt.SelectionCiphertexts != expected {
+				t.Errorf("%d packed ciphertexts for %d queries (%d per ciphertext), expected %d", agt.SelectionCiphertexts, len(states), perCiphertext, expected)
+			}
+		})
+	}
+}
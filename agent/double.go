@@ -0,0 +1,113 @@
+package agent
+
+import "github.com/tuneinsight/lattigo/v4/rlwe"
+
+// Double Q学習: 2つのQテーブルQ_A, Q_Bを持ち，更新するテーブルを等確率で選ぶ
+// 更新するテーブルで次の状態の最良の行動を選び，もう一方のテーブルでその行動を評価することで，Q学習の最大化バイアスを抑える
+// クラウド上のQテーブルもQ_AとQ_Bの2つに分け，行動選択では2つの行の和を暗号文のまま求める
+// 和が固定小数点表現の範囲に収まるように，各テーブルのQ値は暗号化できる範囲の半分までとする (SummedQtables)
+type DoubleQLearning struct{}
+
+func (DoubleQLearning) Name() string   { return "double-q" }
+func (DoubleQLearning) OnPolicy() bool { return false }
+
+func (DoubleQLearning) Target(a *Agent, t Transition) float64 {
+	target := float64(t.Reward)
+	if !t.Terminated {
+		updating, evaluating := a.Qtable, a.QtableB
+		if a.updatingB {
+			updating, evaluating = a.QtableB, a.Qtable
+		}
+		target += a.Gamma * evaluating[t.NextState][a.maxAction(updating[t.NextState])]
+	}
+	return target
+}
+
+func (a *Agent) IsDouble() bool {
+	_, ok := a.Learner.(DoubleQLearning)
+	return ok
+}
+
+// 行動価値を求めるために和を取るQテーブルの数 (Double Q学習ではQ_AとQ_Bの2つ．それ以外では1つ)
+// クラウドでは暗号文のまま和を取るので，和が固定小数点表現の範囲に収まるように各Q値の範囲を狭める
+func SummedQtables(learner Learner) int {
+	if _, ok := learner.(DoubleQLearning); ok {
+		return 2
+	}
+	return 1
+}
+
+func (a *Agent) SummedQtables() int {
+	return SummedQtables(a.Learner)
+}
+
+// クラウド上のQテーブルの暗号文の数 (Double Q学習ではQ_AとQ_Bの2つ分)
+// Double Q学習では，クラウドのQテーブルは前半をQ_A，後半をQ_Bとして連結して渡す
+func (a *Agent) EncryptedQtableRows() int {
+	if a.IsDouble() {
+		return 2 * a.stateNum
+	}
+	return a.stateNum
+}
+
+// 連結したクラウドのQテーブルをQ_AとQ_Bに分ける (要素は共有するので，更新は元のテーブルに反映される)
+func (a *Agent) splitEncryptedQtable(encryptedQtable []*rlwe.Ciphertext) ([]*rlwe.Ciphertext, []*rlwe.Ciphertext) {
+	return encryptedQtable[:a.stateNum], encryptedQtable[a.stateNum:]
+}
+
+// 方策を表すQテーブル (Double Q学習ではQ_AとQ_Bの和．それ以外ではQtable)
+func (a *Agent) PolicyQtable() [][]float64 {
+	if !a.IsDouble() {
+		return a.Qtable
+	}
+	return sumQtables(a.Qtable, a.QtableB)
+}
+
+// 復号したクラウドのQテーブルから方策を表すQテーブルを求める (Double Q学習では連結したQ_AとQ_Bの和)
+func (a *Agent) CloudPolicyQtable(decryptedQtable [][]float64) [][]float64 {
+	if !a.IsDouble() {
+		return decryptedQtable
+	}
+	return sumQtables(decryptedQtable[:a.stateNum], decryptedQtable[a.stateNum:])
+}
+
+func sumQtables(qtableA [][]float64, qtableB [][]float64) [][]float64 {
+	sum := make([][]float64, len(qtableA))
+	for state := range sum {
+		sum[state] = make([]float64, len(qtableA[state]))
+		for action := range sum[state] {
+			sum[state][action] = qtableA[state][action] + qtableB[state][action]
+		}
+	}
+	return sum
+}
+
+// エージェントのQテーブルをクラウドのQテーブルと同じ並びで返す (Double Q学習ではQ_AとQ_Bを連結する)
+func (a *Agent) CloudLayoutQtable() [][]float64 {
+	if !a.IsDouble() {
+		return a.Qtable
+	}
+	return append(append([][]float64{}, a.Qtable...), a.QtableB...)
+}
+
+// エージェントのQテーブルを保存したQ値で置き換える (Double Q学習以外ではqtableBは使用しない)
+// QtableReset()の後に呼び出すこと
+func (a *Agent) SetQtables(qtable [][]float64, qtableB [][]float64) {
+	for state := range a.Qtable {
+		copy(a.Qtable[state], qtable[state])
+	}
+	if a.IsDouble() {
+		for state := range a.QtableB {
+			copy(a.QtableB[state], qtableB[state])
+		}
+	}
+}
+
+// エージェントのQテーブルを復号したクラウドのQテーブルで置き換える (Double Q学習では前半をQ_A，後半をQ_Bとする)
+func (a *Agent) SetQtablesFromCloud(decryptedQtable [][]float64) {
+	if !a.IsDouble() {
+		a.SetQtables(decryptedQtable, nil)
+		return
+	}
+	a.SetQtables(decryptedQtable[:a.stateNum], decryptedQtable[a.stateNum:])
+}
@@ -0,0 +1,97 @@
+package agent
+
+const DEFAULT_PLANNING_STEPS = 10 // Dyna-Qで1ステップ毎に行う計画の回数のデフォルト値
+
+// 計画で更新したQ値をクラウドのQテーブルに反映する方法
+const (
+	PLANNING_PUSH_STEP  = "step"  // 実際の遷移・計画の1回毎に1回の要求で反映する
+	PLANNING_PUSH_BATCH = "batch" // 実際の遷移と計画で更新した全てのQ値を1回の要求にまとめて反映する
+)
+
+// モデルに記録した遷移の結果 (最後に観測したものを保持する決定的なモデル)
+type modelEntry struct {
+	Reward     int
+	NextState  int
+	Terminated bool
+}
+
+// Dyna-Q: 実際の遷移でQ学習の更新をした後，エージェントの手元で学習した遷移のモデルからN回の計画による更新を行う
+// モデルはエージェントの手元にのみ存在し，クラウドには更新後のQ値だけが送られる
+type DynaQ struct {
+	Planning int    // 1ステップ毎の計画の回数 (0ではQ学習と同じになる)
+	Push     string // 計画で更新したQ値の反映方法 (PLANNING_PUSH_*)
+	model    map[[2]int]modelEntry
+	observed [][2]int // モデルに記録した(状態, 行動) (計画で等確率に選ぶため，記録した順に保持する)
+}
+
+func NewDynaQ() *DynaQ {
+	return &DynaQ{
+		Planning: DEFAULT_PLANNING_STEPS,
+		Push:     PLANNING_PUSH_BATCH,
+		model:    map[[2]int]modelEntry{},
+	}
+}
+
+func (l *DynaQ) Name() string { return "dyna-q" }
+
+func (l *DynaQ) OnPolicy() bool { return false }
+
+func (l *DynaQ) Target(a *Agent, t Transition) float64 {
+	return QLearning{}.Target(a, t)
+}
+
+// 学習したモデルを破棄する
+func (l *DynaQ) Reset() {
+	l.model = map[[2]int]modelEntry{}
+	l.observed = nil
+}
+
+func (l *DynaQ) Updates(a *Agent, t Transition) [][]QUpdate {
+	// 実際の遷移による更新
+	requests := [][]QUpdate{{l.update(a, t)}}
+
+	// モデルの学習 (打ち切りは環境の性質ではないので記録しない)
+	key := [2]int{t.State, t.Action}
+	if _, ok := l.model[key]; !ok {
+		l.observed = append(l.observed, key)
+	}
+	l.model[key] = modelEntry{Reward: t.Reward, NextState: t.NextState, Terminated: t.Terminated}
+
+	// 計画: 記録した(状態, 行動)を等確率に選び，モデルが予測する遷移で更新する
+	for i := 0; i < l.Planning; i++ {
+		key := l.observed[a.rng.Intn(len(l.observed))]
+		entry := l.model[key]
+		simulated := Transition{State: key[0], Action: key[1], Reward: entry.Reward, NextState: entry.NextState, NextAction: -1, Terminated: entry.Terminated}
+		requests = append(requests, []QUpdate{l.update(a, simulated)})
+	}
+
+	if l.Push == PLANNING_PUSH_STEP {
+		return requests
+	}
+	return [][]QUpdate{mergeUpdates(requests)}
+}
+
+// 1つの遷移でQ学習の更新を行う
+func (l *DynaQ) update(a *Agent, t Transition) QUpdate {
+	alpha := a.alpha(t.State, t.Action)
+	a.Qtable[t.State][t.Action] = (1-alpha)*a.Qtable[t.State][t.Action] + alpha*l.Target(a, t)
+	return QUpdate{State: t.State, Action: t.Action, Qnew: a.Qtable[t.State][t.Action]}
+}
+
+// 複数の要求を1つにまとめる (同じ(状態, 行動)は最後の値のみを残し，最初に更新した順に並べる)
+func mergeUpdates(requests [][]QUpdate) []QUpdate {
+	index := map[[2]int]int{}
+	merged := []QUpdate{}
+	for _, updates := range requests {
+		for _, update := range updates {
+			key := [2]int{update.State, update.Action}
+			if i, ok := index[key]; ok {
+				merged[i] = update
+				continue
+			}
+			index[key] = len(merged)
+			merged = append(merged, update)
+		}
+	}
+	return merged
+}
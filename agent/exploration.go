@@ -0,0 +1,243 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+)
+
+// 探索のパラメータの減衰の種類
+const (
+	DECAY_NONE        = "none"        // 減衰させない (Startのまま)
+	DECAY_LINEAR      = "linear"      // Episodesエピソードで StartからEndまで線形に減らす
+	DECAY_EXPONENTIAL = "exponential" // End + (Start-End) * exp(-エピソード/Episodes) で指数的に減らす
+)
+
+const (
+	DEFAULT_TEMPERATURE     = 1.0  // softmax方策の温度の初期値のデフォルト値
+	DEFAULT_TEMPERATURE_END = 0.05 // softmax方策の温度の最終値のデフォルト値
+	DEFAULT_EPSILON_END     = 0.01 // εの最終値のデフォルト値
+	DEFAULT_UCB_C           = 1.0  // UCBの探索の重みのデフォルト値
+)
+
+// エピソード数に応じて減衰させる探索のパラメータ (εやsoftmaxの温度)
+type DecaySchedule struct {
+	Kind     string  // 減衰の種類 (DECAY_*)
+	Start    float64 // 最初のエピソードの値
+	End      float64 // 減衰させた後の値
+	Episodes int     // DECAY_LINEAR: Endに達するまでのエピソード数，DECAY_EXPONENTIAL: 時定数
+}
+
+func (d DecaySchedule) Validate() error {
+	switch d.Kind {
+	case DECAY_NONE:
+	case DECAY_LINEAR, DECAY_EXPONENTIAL:
+		if d.Episodes <= 0 {
+			return fmt.Errorf("decay episodes must be positive, got %d", d.Episodes)
+		}
+	default:
+		return fmt.Errorf("unknown decay %q (options: %s, %s, %s)", d.Kind, DECAY_NONE, DECAY_LINEAR, DECAY_EXPONENTIAL)
+	}
+	return nil
+}
+
+// 指定したエピソードでの値
+func (d DecaySchedule) At(episode int) float64 {
+	switch d.Kind {
+	case DECAY_LINEAR:
+		if episode >= d.Episodes {
+			return d.End
+		}
+		return d.Start + (d.End-d.Start)*float64(episode)/float64(d.Episodes)
+	case DECAY_EXPONENTIAL:
+		return d.End + (d.Start-d.End)*math.Exp(-float64(episode)/float64(d.Episodes))
+	default:
+		return d.Start
+	}
+}
+
+// 探索方策
+// 行動価値は必要になったときだけ取得する (秘匿計算の経路ではクラウドへの問い合わせになる) ため，選択を2段階に分ける
+//  1. Explore(): 行動価値を使わずに行動を決められる場合 (ε-greedyの探索など) はその行動を返し，それ以外は-1を返す
+//  2. Choose(): Explore()が-1を返した状態について，平文のQテーブルの行か，秘匿計算で復号した行から行動を選ぶ
+//
+// 乱数はエージェントの乱数生成器(a.rng)を使用する
+type ExplorationPolicy interface {
+	Name() string
+	Explore(a *Agent, state int) int
+	Choose(a *Agent, state int, values []float64) int
+	// 試行の開始時にスケジュールや訪問回数を初期化する
+	Reset(a *Agent)
+	// 1エピソード(ベクトル化環境ではvec.Num()エピソードの区切り)の終了時にスケジュールを進める
+	EndEpisode(a *Agent)
+}
+
+// 行動価値から各行動を選ぶ確率を求められる探索方策 (Expected SARSAの目標値の期待値に使用する)
+// UCBのように行動価値以外(訪問回数)にも依存して決定的に選ぶ方策は実装しない
+type StochasticPolicy interface {
+	ExplorationPolicy
+	Probabilities(a *Agent, values []float64) []float64
+}
+
+// ε-greedy方策: 確率εでランダムに，それ以外は最大のQ値を持つ行動を選ぶ
+// 現在のεはAgent.Epsilonに設定する (Expected SARSAの目標値もこのεを使う)
+type EpsilonGreedy struct {
+	Decay   DecaySchedule // εの減衰 (DECAY_NONEではAgent.Epsilonを変更しない)
+	episode int
+}
+
+func NewEpsilonGreedy() *EpsilonGreedy {
+	return &EpsilonGreedy{Decay: DecaySchedule{Kind: DECAY_NONE, Start: EPSILON, End: DEFAULT_EPSILON_END}}
+}
+
+func (p *EpsilonGreedy) Name() string { return "epsilon" }
+
+func (p *EpsilonGreedy) Explore(a *Agent, state int) int {
+	// εより小さいランダムな値を生成してランダムに行動を選択
+	a.lastEpsilonDraw = a.rng.Float64()
+	if a.lastEpsilonDraw < a.Epsilon {
+		return a.ChooseRandomAction()
+	}
+	return -1
+}
+
+func (p *EpsilonGreedy) Choose(a *Agent, state int, values []float64) int {
+	return a.greedyAction(values)
+}
+
+// 探索では全ての行動を等確率で選び，それ以外では最大のQ値を持つ行動(同じ値の場合はインデックスが小さい行動)を選ぶ
+// (同じ値の行動のどれを選んでもQ値の期待値は変わらない)
+func (p *EpsilonGreedy) Probabilities(a *Agent, values []float64) []float64 {
+	probs := make([]float64, len(values))
+	for action := range probs {
+		probs[action] = a.Epsilon / float64(len(values))
+	}
+	probs[a.maxAction(values)] += 1 - a.Epsilon
+	return probs
+}
+
+func (p *EpsilonGreedy) Reset(a *Agent) {
+	p.episode = 0
+	if p.Decay.Kind != DECAY_NONE {
+		a.Epsilon = p.Decay.At(0)
+	}
+}
+
+func (p *EpsilonGreedy) EndEpisode(a *Agent) {
+	p.episode++
+	if p.Decay.Kind != DECAY_NONE {
+		a.Epsilon = p.Decay.At(p.episode)
+	}
+}
+
+// softmax(Boltzmann)方策: Q値/温度 のsoftmaxに比例する確率で行動を選ぶ
+// 全ての行動選択で行動価値が必要になるので，毎ステップクラウドに問い合わせる
+type Softmax struct {
+	Temperature DecaySchedule // 温度の減衰 (温度が低いほど貪欲になる)
+	episode     int
+}
+
+func NewSoftmax() *Softmax {
+	return &Softmax{Temperature: DecaySchedule{Kind: DECAY_NONE, Start: DEFAULT_TEMPERATURE, End: DEFAULT_TEMPERATURE_END}}
+}
+
+func (p *Softmax) Name() string { return "softmax" }
+
+func (p *Softmax) Explore(a *Agent, state int) int { return -1 }
+
+func (p *Softmax) Choose(a *Agent, state int, values []float64) int {
+	a.lastEpsilonDraw = a.rng.Float64()
+	threshold := a.lastEpsilonDraw
+	for action, prob := range p.Probabilities(a, values) {
+		threshold -= prob
+		if threshold < 0 {
+			return action
+		}
+	}
+	return len(values) - 1
+}
+
+// 現在の温度での Q値/温度 のsoftmax
+func (p *Softmax) Probabilities(a *Agent, values []float64) []float64 {
+	temperature := p.Temperature.At(p.episode)
+
+	// オーバーフローを避けるため最大値を引いてから指数をとる
+	maxValue := a.maxValue(values)
+	probs := make([]float64, len(values))
+	total := 0.0
+	for action, value := range values {
+		probs[action] = math.Exp((value - maxValue) / temperature)
+		total += probs[action]
+	}
+	for action := range probs {
+		probs[action] /= total
+	}
+	return probs
+}
+
+func (p *Softmax) Reset(a *Agent) {
+	p.episode = 0
+}
+
+func (p *Softmax) EndEpisode(a *Agent) {
+	p.episode++
+}
+
+// 訪問回数に基づくUCB方策: Q(s,a) + C * sqrt(ln N(s) / N(s,a)) が最大の行動を選ぶ
+// N(s,a)はAgent.Learn()で数える実際に実行した遷移の回数 (Agent.Visits) で，N(s)はその状態での合計とする
+// 状態で一度も実行していない行動がある場合は，行動価値を問い合わせずにその中からランダムに選ぶ
+// 行動の選択確率を行動価値だけから求められないので，Expected SARSAとは組み合わせられない
+type UCB struct {
+	C float64 // 探索の重み
+}
+
+func NewUCB() *UCB {
+	return &UCB{C: DEFAULT_UCB_C}
+}
+
+func (p *UCB) Name() string { return "ucb" }
+
+func (p *UCB) Explore(a *Agent, state int) int {
+	untried := []int{}
+	for action, count := range a.visits[state] {
+		if count == 0 {
+			untried = append(untried, action)
+		}
+	}
+	if len(untried) == 0 {
+		return -1
+	}
+
+	return untried[a.rng.Intn(len(untried))]
+}
+
+func (p *UCB) Choose(a *Agent, state int, values []float64) int {
+	total := 0
+	for _, count := range a.visits[state] {
+		total += count
+	}
+
+	bonusValues := make([]float64, len(values))
+	for action, value := range values {
+		bonusValues[action] = value + p.C*math.Sqrt(math.Log(float64(total))/float64(a.visits[state][action]))
+	}
+	return a.greedyAction(bonusValues)
+}
+
+// 訪問回数はAgent.QtableReset()で初期化される
+func (p *UCB) Reset(a *Agent) {}
+
+func (p *UCB) EndEpisode(a *Agent) {}
+
+// 名前から探索方策を取得 (コマンドライン引数で使用する)
+func ExplorationByName(name string) (ExplorationPolicy, error) {
+	switch name {
+	case "epsilon":
+		return NewEpsilonGreedy(), nil
+	case "softmax":
+		return NewSoftmax(), nil
+	case "ucb":
+		return NewUCB(), nil
+	default:
+		return nil, fmt.Errorf("unknown exploration policy %q (options: epsilon, softmax, ucb)", name)
+	}
+}
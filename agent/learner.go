@@ -0,0 +1,132 @@
+package agent
+
+import "fmt"
+
+// 1ステップ分の遷移 (Learnerが目標値を求めるために使用する)
+type Transition struct {
+	State      int
+	Action     int
+	Reward     int
+	NextState  int
+	NextAction int  // 次の状態で選択した行動 (OnPolicy()がtrueの学習器のみ使用する．それ以外では-1でもよい)
+	Terminated bool // 次の状態が終了状態の場合はブートストラップしない
+	Truncated  bool // 最大ステップ数で打ち切られた (エピソードをまたぐ状態を持つ学習器はここでエピソードを区切る)
+}
+
+// 1ステップのTD学習の目標値を求める学習アルゴリズム
+// Agent.Learn()は目標値から Qnew = (1-α)Q(s,a) + α*目標値 を求め，同じ秘匿計算のプロトコルでクラウドのQテーブルに反映する
+// 学習率αはAgent.LearningRateと訪問回数 N(s,a) から決める
+type Learner interface {
+	Name() string
+	// 次の状態で実際に選択する行動を目標値に使用するか (trueの場合はTransition.NextActionを設定すること)
+	OnPolicy() bool
+	// 遷移に対する目標値 r + γ * (次の状態の価値)
+	Target(a *Agent, t Transition) float64
+}
+
+// 1ステップで複数のQ値を更新する学習アルゴリズム (適格度トレースなど)
+// 更新した全てのQ値はAgent.Learn()が1回の秘匿計算の要求でクラウドのQテーブルに反映する
+// エピソードをまたぐ状態を持つので，ベクトル化環境では使用できない
+// Reset()を持つ場合は，Agent.QtableReset()で試行ごとに状態を初期化する
+type BatchLearner interface {
+	Learner
+	// 遷移を学習してエージェントのQテーブルを更新し，更新した(状態, 行動, 新しいQ値)を返す
+	Update(a *Agent, t Transition) []QUpdate
+}
+
+// 1ステップの学習で更新したQ値を，複数回の要求に分けてクラウドのQテーブルに反映する学習アルゴリズム (Dyna-Qなど)
+// Agent.Learn()は返された要求ごとに1回の秘匿計算でクラウドのQテーブルを更新する
+type MultiBatchLearner interface {
+	Learner
+	// 遷移を学習してエージェントのQテーブルを更新し，クラウドへの要求ごとに更新した(状態, 行動, 新しいQ値)を返す
+	Updates(a *Agent, t Transition) [][]QUpdate
+}
+
+// 更新したQテーブルの1要素
+type QUpdate struct {
+	State  int
+	Action int
+	Qnew   float64
+}
+
+// Q学習: 次の状態の最大のQ値でブートストラップする
+type QLearning struct{}
+
+// SARSA: 次の状態で実際に選択した行動のQ値でブートストラップする
+type SARSA struct{}
+
+// Expected SARSA: 次の状態のQ値の，行動選択に使う探索方策(StochasticPolicy)による期待値でブートストラップする
+type ExpectedSARSA struct{}
+
+func (QLearning) Name() string     { return "q" }
+func (SARSA) Name() string         { return "sarsa" }
+func (ExpectedSARSA) Name() string { return "expected-sarsa" }
+
+func (QLearning) OnPolicy() bool     { return false }
+func (SARSA) OnPolicy() bool         { return true }
+func (ExpectedSARSA) OnPolicy() bool { return false }
+
+func (QLearning) Target(a *Agent, t Transition) float64 {
+	target := float64(t.Reward) // 報酬は整数値なので実数値にキャストする
+	if !t.Terminated {
+		target += a.Gamma * a.maxValue(a.Qtable[t.NextState])
+	}
+	return target
+}
+
+func (SARSA) Target(a *Agent, t Transition) float64 {
+	target := float64(t.Reward)
+	if !t.Terminated {
+		target += a.Gamma * a.Qtable[t.NextState][t.NextAction]
+	}
+	return target
+}
+
+func (ExpectedSARSA) Target(a *Agent, t Transition) float64 {
+	target := float64(t.Reward)
+	if !t.Terminated {
+		target += a.Gamma * a.expectedValue(a.Qtable[t.NextState])
+	}
+	return target
+}
+
+// 探索方策に従って行動したときのQ値の期待値
+// 選択確率を求められない探索方策(UCB)とExpected SARSAの組み合わせはmainで拒否しておくこと
+func (a *Agent) expectedValue(actions_Q []float64) float64 {
+	policy, ok := a.Exploration.(StochasticPolicy)
+	if !ok {
+		panic(fmt.Sprintf("agent: expected SARSA needs action probabilities, which the %s exploration policy does not provide", a.Exploration.Name()))
+	}
+
+	expected := 0.0
+	for action, prob := range policy.Probabilities(a, actions_Q) {
+		expected += prob * actions_Q[action]
+	}
+	return expected
+}
+
+// 名前から学習アルゴリズムを取得 (コマンドライン引数で使用する)
+func LearnerByName(name string) (Learner, error) {
+	switch name {
+	case "q":
+		return QLearning{}, nil
+	case "sarsa":
+		return SARSA{}, nil
+	case "expected-sarsa":
+		return ExpectedSARSA{}, nil
+	case "double-q":
+		return DoubleQLearning{}, nil
+	case "q-lambda":
+		return NewEligibilityTraces(false), nil
+	case "sarsa-lambda":
+		return NewEligibilityTraces(true), nil
+	case "mc-first":
+		return NewMonteCarlo(true), nil
+	case "mc-every":
+		return NewMonteCarlo(false), nil
+	case "dyna-q":
+		return NewDynaQ(), nil
+	default:
+		return nil, fmt.Errorf("unknown learner %q (options: q, sarsa, expected-sarsa, double-q, q-lambda, sarsa-lambda, mc-first, mc-every, dyna-q)", name)
+	}
+}
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+)
+
+// 学習率のスケジュールの種類
+const (
+	LR_CONSTANT   = "constant"   // 一定の学習率 Alpha
+	LR_INVERSE    = "inverse"    // 1 / N(s,a) (Q値が目標値の標本平均になる)
+	LR_POLYNOMIAL = "polynomial" // 1 / N(s,a)^Exponent (Exponentは(0.5, 1]で収束が保証される)
+)
+
+// (状態, 行動)の訪問回数 N(s,a) に応じた学習率 (実験設定のJSONで指定する)
+// 学習率はエージェントの平文のQ値の計算にのみ使うので，秘匿計算のプロトコルは変わらない
+type LearningRate struct {
+	Schedule string  `json:"schedule"`           // スケジュールの種類 (LR_*)
+	Alpha    float64 `json:"alpha,omitempty"`    // LR_CONSTANT: 学習率
+	Exponent float64 `json:"exponent,omitempty"` // LR_POLYNOMIAL: 訪問回数の指数
+	Min      float64 `json:"min,omitempty"`      // 学習率の下限 (0の場合は下限なし)
+}
+
+// 学習率を指定しなかった場合の一定の学習率
+func DefaultLearningRate() LearningRate {
+	return LearningRate{Schedule: LR_CONSTANT, Alpha: ALPHA}
+}
+
+func (l LearningRate) Validate() error {
+	switch l.Schedule {
+	case LR_CONSTANT:
+		if l.Alpha <= 0 || l.Alpha > 1 {
+			return fmt.Errorf("learning rate: alpha must be in (0, 1], got %f", l.Alpha)
+		}
+	case LR_INVERSE:
+	case LR_POLYNOMIAL:
+		if l.Exponent <= 0 || l.Exponent > 1 {
+			return fmt.Errorf("learning rate: exponent must be in (0, 1], got %f", l.Exponent)
+		}
+	default:
+		return fmt.Errorf("unknown learning rate schedule %q (options: %s, %s, %s)", l.Schedule, LR_CONSTANT, LR_INVERSE, LR_POLYNOMIAL)
+	}
+	if l.Min < 0 || l.Min > 1 {
+		return fmt.Errorf("learning rate: min must be in [0, 1], got %f", l.Min)
+	}
+	return nil
+}
+
+// 訪問回数がvisits回のときの学習率 (未訪問の場合は1回として扱う)
+func (l LearningRate) At(visits int) float64 {
+	n := math.Max(float64(visits), 1)
+
+	var alpha float64
+	switch l.Schedule {
+	case LR_INVERSE:
+		alpha = 1 / n
+	case LR_POLYNOMIAL:
+		alpha = 1 / math.Pow(n, l.Exponent)
+	default:
+		alpha = l.Alpha
+	}
+	return math.Max(alpha, l.Min)
+}
+
+// 実際の遷移で(状態, 行動)を訪れた回数を数える (Agent.Learn()で呼び出す)
+func (a *Agent) visit(state_1D int, action int) {
+	a.visits[state_1D][action]++
+}
+
+// (状態, 行動)を実際の遷移で訪れた回数 N(s,a)
+func (a *Agent) Visits(state_1D int, action int) int {
+	return a.visits[state_1D][action]
+}
+
+// (状態, 行動)のQ値の更新に使う学習率
+func (a *Agent) alpha(state_1D int, action int) float64 {
+	return a.LearningRate.At(a.visits[state_1D][action])
+}
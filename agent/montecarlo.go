@@ -0,0 +1,79 @@
+package agent
+
+// モンテカルロ法による制御
+// エピソード中の遷移を記録しておき，エピソードの終了時に各(状態, 行動)の収益 G で Q ← Q + α(G - Q) と更新する
+// 更新した全てのQ値はエピソードごとに1回の要求でクラウドのQテーブルに反映する
+// 最大ステップ数で打ち切られたエピソードは，TD法の学習器と同じく打ち切られた状態の価値 max_a Q(s_T, a) でブートストラップする
+// (打ち切り後の報酬を0とみなすと，打ち切られたエピソードの収益が偏ってしまう)
+type MonteCarlo struct {
+	FirstVisit bool // trueの場合は初回訪問MC (エピソード中で最初に訪れた時点の収益のみを使う)，falseの場合は逐一訪問MC
+	episode    []Transition
+}
+
+func NewMonteCarlo(firstVisit bool) *MonteCarlo {
+	return &MonteCarlo{FirstVisit: firstVisit}
+}
+
+func (l *MonteCarlo) Name() string {
+	if l.FirstVisit {
+		return "mc-first"
+	}
+	return "mc-every"
+}
+
+func (l *MonteCarlo) OnPolicy() bool { return false }
+
+// モンテカルロ法はブートストラップしないので，1ステップの報酬のみを返す (更新にはUpdate()で求めた収益を使う)
+func (l *MonteCarlo) Target(a *Agent, t Transition) float64 {
+	return float64(t.Reward)
+}
+
+// 記録中のエピソードを破棄する
+func (l *MonteCarlo) Reset() {
+	l.episode = nil
+}
+
+func (l *MonteCarlo) Update(a *Agent, t Transition) []QUpdate {
+	l.episode = append(l.episode, t)
+	if !t.Terminated && !t.Truncated {
+		return nil
+	}
+
+	// 初回訪問MCのため，各(状態, 行動)を最初に訪れたステップを求める
+	firstVisit := map[[2]int]int{}
+	for step, transition := range l.episode {
+		key := [2]int{transition.State, transition.Action}
+		if _, ok := firstVisit[key]; !ok {
+			firstVisit[key] = step
+		}
+	}
+
+	// エピソードの後ろから収益を求めて更新する
+	updated := map[[2]int]bool{}
+	order := [][2]int{}
+	G := 0.0
+	if last := l.episode[len(l.episode)-1]; !last.Terminated {
+		G = a.maxValue(a.Qtable[last.NextState])
+	}
+	for step := len(l.episode) - 1; step >= 0; step-- {
+		transition := l.episode[step]
+		G = float64(transition.Reward) + a.Gamma*G
+
+		key := [2]int{transition.State, transition.Action}
+		if l.FirstVisit && firstVisit[key] != step {
+			continue
+		}
+		a.Qtable[key[0]][key[1]] += a.alpha(key[0], key[1]) * (G - a.Qtable[key[0]][key[1]])
+		if !updated[key] {
+			updated[key] = true
+			order = append(order, key)
+		}
+	}
+	l.episode = nil
+
+	updates := make([]QUpdate, len(order))
+	for i, key := range order {
+		updates[i] = QUpdate{State: key[0], Action: key[1], Qnew: a.Qtable[key[0]][key[1]]}
+	}
+	return updates
+}
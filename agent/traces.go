@@ -0,0 +1,89 @@
+package agent
+
+// この値より小さくなった適格度は0とみなし，更新の対象から外す (1回の要求で送る更新の数を抑える)
+const TRACE_THRESHOLD = 0.01
+
+const (
+	DEFAULT_LAMBDA = 0.9 // 適格度の減衰率λのデフォルト値
+)
+
+// 適格度トレースを用いるQ(λ) (Watkins) / SARSA(λ)
+// 1ステップのTD誤差で，適格度が残っている全ての(状態, 行動)のQ値を更新する
+type EligibilityTraces struct {
+	Lambda      float64 // 適格度の減衰率λ (0ではQ学習・SARSAと同じ1ステップの更新になる)
+	Accumulate  bool    // trueの場合は累積トレース，falseの場合は置換トレース
+	OnPolicyTD  bool    // trueの場合はSARSA(λ)，falseの場合はQ(λ)
+	traces      map[[2]int]float64
+	traceStates [][2]int // 適格度を付けた順序 (更新の順序を決定的にするため)
+}
+
+func NewEligibilityTraces(onPolicy bool) *EligibilityTraces {
+	return &EligibilityTraces{
+		Lambda:     DEFAULT_LAMBDA,
+		OnPolicyTD: onPolicy,
+		traces:     map[[2]int]float64{},
+	}
+}
+
+func (l *EligibilityTraces) Name() string {
+	if l.OnPolicyTD {
+		return "sarsa-lambda"
+	}
+	return "q-lambda"
+}
+
+// Q(λ)でも，探索的な行動を選んだ場合に適格度を打ち切るため次の行動が必要になる
+func (l *EligibilityTraces) OnPolicy() bool { return true }
+
+func (l *EligibilityTraces) Target(a *Agent, t Transition) float64 {
+	if l.OnPolicyTD {
+		return SARSA{}.Target(a, t)
+	}
+	return QLearning{}.Target(a, t)
+}
+
+// 全ての適格度を0にする
+func (l *EligibilityTraces) Reset() {
+	l.traces = map[[2]int]float64{}
+	l.traceStates = nil
+}
+
+func (l *EligibilityTraces) Update(a *Agent, t Transition) []QUpdate {
+	key := [2]int{t.State, t.Action}
+	delta := l.Target(a, t) - a.Qtable[t.State][t.Action]
+	// Q(λ)で次の行動が貪欲でない(探索的な)かは，次の行動を選んだときの(更新前の)Q値で判定する
+	explored := !l.OnPolicyTD && !t.Terminated && t.NextAction >= 0 && a.Qtable[t.NextState][t.NextAction] != a.maxValue(a.Qtable[t.NextState])
+
+	if _, ok := l.traces[key]; !ok {
+		l.traceStates = append(l.traceStates, key)
+	}
+	if l.Accumulate {
+		l.traces[key]++
+	} else {
+		l.traces[key] = 1
+	}
+
+	updates := make([]QUpdate, 0, len(l.traceStates))
+	kept := l.traceStates[:0]
+	for _, traced := range l.traceStates {
+		trace := l.traces[traced]
+		a.Qtable[traced[0]][traced[1]] += a.alpha(traced[0], traced[1]) * delta * trace
+		updates = append(updates, QUpdate{State: traced[0], Action: traced[1], Qnew: a.Qtable[traced[0]][traced[1]]})
+
+		trace *= a.Gamma * l.Lambda
+		if trace < TRACE_THRESHOLD {
+			delete(l.traces, traced)
+			continue
+		}
+		l.traces[traced] = trace
+		kept = append(kept, traced)
+	}
+	l.traceStates = kept
+
+	// エピソードの終了時と，Q(λ)で次の行動が探索的な場合は適格度を打ち切る
+	if t.Terminated || t.Truncated || explored {
+		l.Reset()
+	}
+
+	return updates
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"pprlgoFrozenLake/agent"
+	"pprlgoFrozenLake/environment"
+)
+
+// 実験設定 (-configオプションでJSONファイルから読み込む)
+type ExperimentConfig struct {
+	RewardScheme string                    `json:"reward_scheme"`           // 報酬の種類 ("default", "sparse")
+	Rewards      *environment.RewardConfig `json:"rewards,omitempty"`       // 報酬を個別に指定する場合に使用 (RewardSchemeより優先する)
+	Schedule     environment.Schedule      `json:"schedule,omitempty"`      // 学習中に湖を変化させる予定 (空の場合は湖は変化しない)
+	LearningRate *agent.LearningRate       `json:"learning_rate,omitempty"` // 学習率のスケジュール (省略した場合はagent.ALPHAで一定)
+}
+
+// 設定ファイルを指定しなかった場合の実験設定
+func Default() ExperimentConfig {
+	return ExperimentConfig{
+		RewardScheme: "default",
+	}
+}
+
+// JSONファイルから実験設定を読み込む
+// ファイルで指定されなかった項目はDefault()の値になる
+func Load(path string) (ExperimentConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ExperimentConfig{}, err
+	}
+	defer file.Close()
+
+	cfg := Default()
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields() // 設定項目の書き間違いを検出する
+	if err := decoder.Decode(&cfg); err != nil {
+		return ExperimentConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if _, err := cfg.RewardConfig(); err != nil {
+		return ExperimentConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err := cfg.LearningRateConfig(); err != nil {
+		return ExperimentConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// 実験で使用する報酬の設定を取得し，Q値が暗号化できる範囲に収まるかを検査する
+// (1つのQテーブルの場合．学習アルゴリズムに応じたQテーブルの数と湖のマス毎の報酬はmainで改めて検査する)
+func (c ExperimentConfig) RewardConfig() (environment.RewardConfig, error) {
+	var rewardCfg environment.RewardConfig
+	if c.Rewards != nil {
+		rewardCfg = *c.Rewards
+	} else {
+		var err error
+		rewardCfg, err = environment.RewardConfigByName(c.RewardScheme)
+		if err != nil {
+			return environment.RewardConfig{}, err
+		}
+	}
+
+	if err := rewardCfg.Validate(agent.GAMMA, 1); err != nil {
+		return environment.RewardConfig{}, err
+	}
+	return rewardCfg, nil
+}
+
+// 実験で使用する学習率のスケジュールを取得し，パラメータの範囲を検査する
+func (c ExperimentConfig) LearningRateConfig() (agent.LearningRate, error) {
+	if c.LearningRate == nil {
+		return agent.DefaultLearningRate(), nil
+	}
+	if err := c.LearningRate.Validate(); err != nil {
+		return agent.LearningRate{}, err
+	}
+	return *c.LearningRate, nil
+}
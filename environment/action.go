@@ -0,0 +1,76 @@
+package environment
+
+import (
+	"fmt"
+	"pprlgoFrozenLake/position"
+)
+
+// エージェントの1つの行動
+type Action struct {
+	Name   string            `json:"name"`   // 行動の名前
+	Symbol string            `json:"symbol"` // 表示用の記号
+	Move   position.Position `json:"move"`   // 移動量
+}
+
+// 環境で選択できる行動の集合 (インデックスが行動のIDになり，暗号化されたQテーブルのスロットに対応する)
+type ActionSet []Action
+
+var (
+	// 上下左右の4方向 (0: "↑", 1: "↓", 2: "←", 3: "→")
+	FourWayActions = ActionSet{
+		{Name: "up", Symbol: "↑", Move: position.Position{Y: -1, X: 0}},
+		{Name: "down", Symbol: "↓", Move: position.Position{Y: 1, X: 0}},
+		{Name: "left", Symbol: "←", Move: position.Position{Y: 0, X: -1}},
+		{Name: "right", Symbol: "→", Move: position.Position{Y: 0, X: 1}},
+	}
+
+	// 4方向に斜めを加えた8方向 (4方向と同じ行動は同じIDになる)
+	EightWayActions = append(FourWayActions[:len(FourWayActions):len(FourWayActions)], ActionSet{
+		{Name: "up-left", Symbol: "↖", Move: position.Position{Y: -1, X: -1}},
+		{Name: "up-right", Symbol: "↗", Move: position.Position{Y: -1, X: 1}},
+		{Name: "down-left", Symbol: "↙", Move: position.Position{Y: 1, X: -1}},
+		{Name: "down-right", Symbol: "↘", Move: position.Position{Y: 1, X: 1}},
+	}...)
+
+	// その場に留まる行動 (no-op)
+	StayAction = Action{Name: "stay", Symbol: "○", Move: position.Position{Y: 0, X: 0}}
+)
+
+// 名前から行動の集合を取得 (コマンドライン引数で使用する)
+func ActionSetByName(name string) (ActionSet, error) {
+	switch name {
+	case "4":
+		return FourWayActions, nil
+	case "8":
+		return EightWayActions, nil
+	case "4+stay":
+		return FourWayActions.WithStay(), nil
+	case "8+stay":
+		return EightWayActions.WithStay(), nil
+	default:
+		return nil, fmt.Errorf("unknown action set %q (options: 4, 8, 4+stay, 8+stay)", name)
+	}
+}
+
+// 末尾にその場に留まる行動を加えた行動の集合
+func (s ActionSet) WithStay() ActionSet {
+	return append(s[:len(s):len(s)], StayAction)
+}
+
+// 移動量がmoveの行動のID (存在しない場合は-1)
+func (s ActionSet) indexOf(move position.Position) int {
+	for i, action := range s {
+		if action.Move == move {
+			return i
+		}
+	}
+	return -1
+}
+
+// 行動を表示用の記号に変換 (記号を持たない環境では行動のIDで表示する)
+func ActionLabel(env Env, action int) string {
+	if labeled, ok := env.(interface{ ActionSymbol(action int) string }); ok {
+		return labeled.ActionSymbol(action)
+	}
+	return fmt.Sprintf("%d", action)
+}
@@ -0,0 +1,43 @@
+package environment
+
+import (
+	"fmt"
+	"pprlgoFrozenLake/position"
+)
+
+// Gymnasiumのenvに相当する表形式(tabular)の環境のインタフェース
+// 状態は 0 から ObservationSpace()-1 までの整数IDで表すので，暗号化されたQテーブルの行にそのまま対応させられる
+type Env interface {
+	// 環境を初期状態に戻し，初期状態のIDを返す
+	Reset() int
+	// 行動を実行し，次の状態のID・報酬・終了状態に到達したか(terminated)・打ち切られたか(truncated)・付加情報を返す
+	Step(action int) (observation int, reward int, terminated bool, truncated bool, info map[string]interface{})
+	// 状態数
+	ObservationSpace() int
+	// 行動数
+	ActionSpace() int
+}
+
+// Step()が返す付加情報(info)のキー
+const (
+	INFO_POSITION = "position" // 移動後の座標 (position.Position)
+	INFO_ACTION   = "action"   // 滑りなどを反映して実際に実行された行動 (int)
+	INFO_GOAL     = "goal"     // ゴール地点に到達したかどうか (bool)
+
+	INFO_GOAL_POSITION = "goal_position" // 到達したゴール地点 (position.Position)．ゴールに到達した場合のみ格納する
+	INFO_STATE         = "state"         // 部分観測の環境で，観測IDとは別に実際の状態ID (int) を格納する
+)
+
+// 状態IDを表示用の文字列に変換 (座標を持つ環境では座標で表示する)
+func StateLabel(env Env, state int) string {
+	if grid, ok := env.(interface {
+		StateToPosition(state int) position.Position
+	}); ok {
+		pos := grid.StateToPosition(state)
+		return fmt.Sprintf("[Y: %d, X: %d]", pos.Y, pos.X)
+	}
+	return fmt.Sprintf("%d", state)
+}
+
+// EnvironmentがEnvを満たしていることをコンパイル時に確認する
+var _ Env = (*Environment)(nil)
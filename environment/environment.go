@@ -1,32 +1,59 @@
 package environment
 
 import (
+	"math/rand"
 	"pprlgoFrozenLake/frozenlake"
 	"pprlgoFrozenLake/position"
 )
 
+// DefaultRewardConfigで使用する報酬
 const (
-	SURFACE_REWARD  = 0   // 地面に移動した場合は報酬0
-	GOAL_REWARD     = 10  // ゴールした場合は正の報酬を与える
-	HOLE_PENALTY    = -10 // 穴に移動した場合のペナルティ
-	OUTSIDE_PENALTY = -10 // 画面外に移動した場合のペナルティ
+	SURFACE_REWARD   = 0   // 地面に移動した場合は報酬0
+	GOAL_REWARD      = 10  // ゴールした場合は正の報酬を与える
+	HOLE_PENALTY     = -10 // 穴に移動した場合のペナルティ
+	OUTSIDE_PENALTY  = -10 // 画面外に移動した場合のペナルティ
+	THIN_ICE_PENALTY = -2  // 薄氷に移動した場合のペナルティ (終了はしない)
+	STEP_PENALTY     = -1  // ステップ数が増えるごとにペナルティも増える
 )
 
 type Environment struct {
-	frozenLake  frozenlake.FrozenLake
-	ActionSpace []int             // エージェントの行動空間 (エージェントを作成する際に行動空間の大きさが知りたいので外部に公開する)
-	agentState  position.Position // エージェントの現在位置
-	rewards     [][]int
-	isHole      map[position.Position]bool // True: 穴, False: 地面
-	StartPos    position.Position
-	GoalPos     position.Position
+	frozenLake frozenlake.FrozenLake
+	Actions    ActionSet         // エージェントの行動空間 (デフォルトは上下左右の4方向)
+	agentState position.Position // エージェントの現在位置
+	rewards    [][]int
+	rewardCfg  RewardConfig
+	isHole     map[position.Position]bool // True: 穴, False: 地面
+	isGoal     map[position.Position]bool // True: ゴール地点
+	StartPos   position.Position
+	GoalPos    position.Position
+	Slip       SlipModel  // 滑りモデル (デフォルトは滑らない)
+	Windy      bool       // trueの場合は湖に設定された風で移動後に押し流す (デフォルトは無風)
+	MaxSteps   int        // 1エピソードの最大ステップ数 (0以下なら制限なし)．超えた場合はtruncatedとして打ち切る
+	stepCount  int        // 現在のエピソードで経過したステップ数
+	rng        *rand.Rand // 滑りモデルで使用する乱数生成器 (環境ごとに独立させて再現性を保つ)
+	seed       int64      // rngに最後に設定したシード
 }
 
-func NewEnvironment(lake frozenlake.FrozenLake) *Environment {
+func NewEnvironment(lake frozenlake.FrozenLake, rewardCfg RewardConfig) *Environment {
+	e := &Environment{
+		Actions:   FourWayActions,
+		rewardCfg: rewardCfg,
+		Slip:      NoSlip,
+		rng:       rand.New(rand.NewSource(0)),
+	}
+	e.SetLake(lake)
+	e.agentState = lake.StartPos // エージェントの位置はスタート地点で初期化
+
+	return e
+}
+
+// 湖を差し替え，マス毎の報酬と穴・ゴールの情報を作り直す
+// 行動・滑りモデル・乱数生成器などの設定は引き継ぐ (非定常な環境で湖を変化させる場合に使用する)
+func (e *Environment) SetLake(lake frozenlake.FrozenLake) {
 	frozenLake := lake
-	actionSpace := []int{0, 1, 2, 3}  // 0: "↑", 1: "↓", 2: "←", 3: "→"
-	agentState := frozenLake.StartPos // エージェントの位置はスタート地点で初期化
+	rewardCfg := e.rewardCfg
 	isHole := make(map[position.Position]bool)
+	isGoal := make(map[position.Position]bool)
 
 	// Height x Width の2次元配列を作成
 	rewards := make([][]int, frozenLake.Height)
@@ -34,34 +61,92 @@ func NewEnvironment(lake frozenlake.FrozenLake) *Environment {
 		rewards[i] = make([]int, frozenLake.Width)
 	}
 
-	// 報酬を設定する: "o"(地面) → 0, "x"(穴) → -1
+	// 報酬を設定する: "o"(地面) → rewardCfg.Surface, "x"(穴) → rewardCfg.Hole, "t"(薄氷) → rewardCfg.ThinIce
 	for y, row := range frozenLake.LakeMap {
 		for x, cell := range row {
 			switch cell {
 			case "o": // 地面
-				rewards[y][x] = SURFACE_REWARD
+				rewards[y][x] = rewardCfg.Surface
 				isHole[position.Position{Y: y, X: x}] = false
 			case "x": // 穴
-				rewards[y][x] = HOLE_PENALTY
+				rewards[y][x] = rewardCfg.Hole
 				isHole[position.Position{Y: y, X: x}] = true
+			case "t": // 薄氷
+				rewards[y][x] = rewardCfg.ThinIce
+				isHole[position.Position{Y: y, X: x}] = false
 			}
 		}
 	}
 
 	// ゴール地点の報酬を設定
-	rewards[frozenLake.GoalPos.Y][frozenLake.GoalPos.X] = GOAL_REWARD
-
-	return &Environment{
-		frozenLake:  frozenLake,
-		ActionSpace: actionSpace,
-		agentState:  agentState,
-		rewards:     rewards,
-		isHole:      isHole,
-		StartPos:    frozenLake.StartPos,
-		GoalPos:     frozenLake.GoalPos,
+	for _, goal := range frozenLake.GoalPositions() {
+		rewards[goal.Y][goal.X] = rewardCfg.Goal
+		isGoal[goal] = true
+	}
+
+	// マス毎に指定された報酬で上書きする (ゴール毎に異なる報酬など)
+	for pos, reward := range frozenLake.CellRewards {
+		rewards[pos.Y][pos.X] = reward
+	}
+
+	e.frozenLake = frozenLake
+	e.rewards = rewards
+	e.isHole = isHole
+	e.isGoal = isGoal
+	e.StartPos = frozenLake.StartPos
+	e.GoalPos = frozenLake.GoalPos
+}
+
+// 行動を表示用の記号に変換
+func (e *Environment) ActionSymbol(action int) string {
+	return e.Actions[action].Symbol
+}
+
+// エージェントの現在位置
+func (e *Environment) AgentPosition() position.Position {
+	return e.agentState
+}
+
+// 指定した地点が穴かどうか
+func (e *Environment) IsHole(pos position.Position) bool {
+	return e.isHole[pos]
+}
+
+// 地点の種類を表す文字 ("F": 地面, "H": 穴, "G": ゴール, "T": 薄氷)．スタート地点は地面として扱う
+func (e *Environment) CellKind(pos position.Position) string {
+	switch {
+	case e.isGoal[pos]:
+		return "G"
+	case e.isHole[pos]:
+		return "H"
+	case e.IsThinIce(pos):
+		return "T"
+	default:
+		return "F"
 	}
 }
 
+// 指定した地点が薄氷かどうか
+func (e *Environment) IsThinIce(pos position.Position) bool {
+	return e.frozenLake.LakeMap[pos.Y][pos.X] == "t"
+}
+
+// 指定した地点がゴール地点かどうか
+func (e *Environment) IsGoal(pos position.Position) bool {
+	return e.isGoal[pos]
+}
+
+// 滑りモデルで使用する乱数のシードを設定
+func (e *Environment) Seed(seed int64) {
+	e.rng = rand.New(rand.NewSource(seed))
+	e.seed = seed
+}
+
+// 環境が使用している報酬の設定
+func (e *Environment) RewardConfig() RewardConfig {
+	return e.rewardCfg
+}
+
 func (e *Environment) Height() int {
 	return e.frozenLake.Height
 }
@@ -70,47 +155,84 @@ func (e *Environment) Width() int {
 	return e.frozenLake.Width
 }
 
-func (e *Environment) Reward(state position.Position, nextState position.Position) int {
-	// 今の状態と次の状態が同じ場合 (画面外に移動した場合) は別途ペナルティを与える
-	if state == nextState {
-		return OUTSIDE_PENALTY
+func (e *Environment) Reward(nextState position.Position, outside bool) int {
+	// 画面外に移動しようとした場合は別途ペナルティを与える
+	if outside {
+		return e.rewardCfg.Outside
 	}
 
 	return e.rewards[nextState.Y][nextState.X]
 }
 
-func (e *Environment) Reset() position.Position {
-	e.agentState = e.frozenLake.StartPos
-	return e.agentState
+// 状態数 (湖のマス数)
+func (e *Environment) ObservationSpace() int {
+	return e.Height() * e.Width()
 }
 
-func (e *Environment) NextState(state position.Position, action int) position.Position {
-	// 行動空間(0: "↑", 1: "↓", 2: "←", 3: "→")に基づいて移動方向を設定
-	actionMoveMap := []position.Position{{Y: -1, X: 0}, {Y: 1, X: 0}, {Y: 0, X: -1}, {Y: 0, X: 1}}
-	move := actionMoveMap[action]
+// 行動数
+func (e *Environment) ActionSpace() int {
+	return len(e.Actions)
+}
 
-	// 現在の状態(state) + 移動方向(move) = 次の状態(nextState)
-	nextState := position.Position{Y: state.Y + move.Y, X: state.X + move.X}
+// Qテーブルの状態(1次元)に格納するため，二次元座標を一次元の状態IDに変換
+func (e *Environment) PositionToState(pos position.Position) int {
+	return pos.Y*e.Width() + pos.X
+}
 
+// 一次元の状態IDを二次元座標に変換
+func (e *Environment) StateToPosition(state int) position.Position {
+	return position.Position{Y: state / e.Width(), X: state % e.Width()}
+}
+
+func (e *Environment) Reset() int {
+	e.agentState = e.frozenLake.StartPos
+	e.stepCount = 0
+	return e.PositionToState(e.agentState)
+}
+
+func (e *Environment) NextState(state position.Position, action int) position.Position {
 	// 移動先が画面外の場合は移動しない
-	if nextState.X < 0 || nextState.X >= e.Width() || nextState.Y < 0 || nextState.Y >= e.Height() {
-		nextState = state
+	if e.movesOutside(state, action) {
+		return state
 	}
 
-	return nextState
+	// 現在の状態(state) + 移動方向(move) = 次の状態(nextState)
+	move := e.Actions[action].Move
+	return position.Position{Y: state.Y + move.Y, X: state.X + move.X}
 }
 
-func (e *Environment) Step(action int) (position.Position, int, bool) {
+// 行動の移動先が画面外かどうか
+func (e *Environment) movesOutside(state position.Position, action int) bool {
+	move := e.Actions[action].Move
+	nextState := position.Position{Y: state.Y + move.Y, X: state.X + move.X}
+	return nextState.X < 0 || nextState.X >= e.Width() || nextState.Y < 0 || nextState.Y >= e.Height()
+}
+
+func (e *Environment) Step(action int) (int, int, bool, bool, map[string]interface{}) {
 	state := e.agentState
-	nextState := e.NextState(state, action)
-	reward := e.Reward(state, nextState) - 1 // ステップ数が増えるごとにペナルティも増える
-	done := false
+	action = e.slipAction(action)                                                   // 滑る床の場合は意図しない方向に進むことがある
+	nextState := e.applyWind(state, e.NextState(state, action))                     // 風がある場合は移動後にさらに押し流される
+	reward := e.Reward(nextState, e.movesOutside(state, action)) + e.rewardCfg.Step // ステップ毎の報酬 (ペナルティ) を加える
 
-	// nextStateが 穴 or ゴール地点 で終了状態となる
+	// nextStateが 穴 or いずれかのゴール地点 で終了状態となる (薄氷では終了しない)
 	// 状態毎の報酬はNewEnvironment関数のrewardsにて設定済み
-	done = e.isHole[nextState] || nextState == e.frozenLake.GoalPos
+	isGoal := e.isGoal[nextState]
+	terminated := e.isHole[nextState] || isGoal
+
+	// 終了状態に到達しないまま最大ステップ数に達した場合は打ち切る (終了状態とは区別する)
+	e.stepCount++
+	truncated := !terminated && e.MaxSteps > 0 && e.stepCount >= e.MaxSteps
 
 	e.agentState = nextState
 
-	return nextState, reward, done
+	info := map[string]interface{}{
+		INFO_POSITION: nextState,
+		INFO_ACTION:   action,
+		INFO_GOAL:     isGoal,
+	}
+	if isGoal {
+		info[INFO_GOAL_POSITION] = nextState
+	}
+
+	return e.PositionToState(nextState), reward, terminated, truncated, info
 }
@@ -0,0 +1,128 @@
+package environment
+
+import (
+	"fmt"
+	"pprlgoFrozenLake/frozenlake"
+)
+
+// エピソード数に応じて湖に変化を起こす予定
+type ScheduledChange struct {
+	Episode int `json:"episode"`         // 変化を起こすエピソード (このエピソードの開始時に適用する)
+	Every   int `json:"every,omitempty"` // 0より大きい場合，Episode以降Everyエピソード毎に繰り返す
+	frozenlake.LakeChange
+}
+
+// 指定したエピソードの開始時に適用するかどうか
+func (c ScheduledChange) At(episode int) bool {
+	if episode == c.Episode {
+		return true
+	}
+	return c.Every > 0 && episode > c.Episode && (episode-c.Episode)%c.Every == 0
+}
+
+// 湖の変化の予定 (実験設定のJSONで宣言的に記述する)
+type Schedule []ScheduledChange
+
+// 指定したエピソードの開始時に適用する変化 (予定に記述した順)
+func (s Schedule) ChangesAt(episode int) []frozenlake.LakeChange {
+	changes := []frozenlake.LakeChange{}
+	for _, scheduled := range s {
+		if scheduled.At(episode) {
+			changes = append(changes, scheduled.LakeChange)
+		}
+	}
+	return changes
+}
+
+// 0〜episodesのエピソードで予定通りに湖を変化させ，変化後の湖が常に整合しているかを検査
+func (s Schedule) Validate(lake frozenlake.FrozenLake, episodes int) error {
+	for _, scheduled := range s {
+		if scheduled.Episode < 0 || scheduled.Every < 0 {
+			return fmt.Errorf("schedule: episode and every must be non-negative: %+v", scheduled)
+		}
+	}
+
+	for episode := 0; episode <= episodes; episode++ {
+		for _, change := range s.ChangesAt(episode) {
+			var err error
+			lake, err = change.Apply(lake)
+			if err != nil {
+				return fmt.Errorf("schedule: episode %d: %w", episode, err)
+			}
+			if err := lake.Validate(); err != nil {
+				return fmt.Errorf("schedule: episode %d: lake after %s: %w", episode, change, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// 湖の変化の記録
+type AppliedChange struct {
+	Episode int
+	Change  frozenlake.LakeChange
+}
+
+// 予定に従って湖が変化する非定常な環境
+// エピソードはReset()の呼び出しで数え，変化は各エピソードの開始時(Reset()の中)に適用する
+type NonStationaryEnv struct {
+	*Environment
+	Schedule Schedule
+	baseLake frozenlake.FrozenLake // 変化させる前の湖 (RestartSchedule()で戻す)
+	episode  int                   // 現在のエピソード番号 (最初のReset()の前は-1)
+	applied  []AppliedChange
+}
+
+// 環境を予定に従って変化させる環境を作成 (予定が空の場合は元の環境と同じ振る舞いになる)
+func NewNonStationaryEnv(env *Environment, schedule Schedule) *NonStationaryEnv {
+	return &NonStationaryEnv{
+		Environment: env,
+		Schedule:    schedule,
+		baseLake:    env.frozenLake.Clone(),
+		episode:     -1,
+	}
+}
+
+// 湖を変化させる前の状態に戻し，エピソードを数え直す (試行の開始時に呼び出す)
+func (n *NonStationaryEnv) RestartSchedule() {
+	n.Environment.SetLake(n.baseLake.Clone())
+	n.episode = -1
+	n.applied = nil
+}
+
+// これまでに適用した変化
+func (n *NonStationaryEnv) AppliedChanges() []AppliedChange {
+	return n.applied
+}
+
+func (n *NonStationaryEnv) Reset() int {
+	n.episode++
+
+	changes := n.Schedule.ChangesAt(n.episode)
+	if len(changes) > 0 {
+		lake := n.Environment.frozenLake
+		for _, change := range changes {
+			var err error
+			lake, err = change.Apply(lake)
+			if err != nil {
+				// 予定はSchedule.Validate()で事前に検査しておくこと
+				panic(err)
+			}
+			n.applied = append(n.applied, AppliedChange{Episode: n.episode, Change: change})
+		}
+		n.Environment.SetLake(lake)
+	}
+
+	return n.Environment.Reset()
+}
+
+// 変化させる前の湖と予定を含めた設定 (再生時は最初の状態から同じ変化を再現する)
+func (n *NonStationaryEnv) Spec() Spec {
+	spec := n.Environment.Spec()
+	spec.Lake = n.baseLake.String()
+	spec.Schedule = n.Schedule
+	return spec
+}
+
+var _ Env = (*NonStationaryEnv)(nil)
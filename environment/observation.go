@@ -0,0 +1,204 @@
+package environment
+
+import (
+	"fmt"
+	"math/rand"
+	"pprlgoFrozenLake/position"
+	"pprlgoFrozenLake/utils"
+	"strings"
+)
+
+// 観測の種類
+const (
+	OBS_FULL   = "full"   // 現在位置の状態IDをそのまま観測する (完全観測)
+	OBS_WINDOW = "window" // 周囲のマスの種類のパターンを観測する
+	OBS_NOISY  = "noisy"  // 一定の確率で隣接するマスの状態IDを観測する
+)
+
+// 局所的な観測で画面外のマスを表す文字
+const OUTSIDE_CELL = "#"
+
+// 部分観測の設定
+type ObservationModel struct {
+	Kind   string  `json:"kind"`             // 観測の種類 (OBS_*)
+	Radius int     `json:"radius,omitempty"` // OBS_WINDOW: 観測する範囲 (現在位置を中心とする (2*Radius+1)x(2*Radius+1) のマス)
+	Noise  float64 `json:"noise,omitempty"`  // OBS_NOISY: 隣接するマスを観測してしまう確率
+}
+
+func (m ObservationModel) Validate() error {
+	switch m.Kind {
+	case OBS_FULL:
+	case OBS_WINDOW:
+		if m.Radius <= 0 {
+			return fmt.Errorf("window radius must be positive, got %d", m.Radius)
+		}
+	case OBS_NOISY:
+		if m.Noise < 0 || m.Noise > 1 {
+			return fmt.Errorf("observation noise must be in [0, 1], got %f", m.Noise)
+		}
+	default:
+		return fmt.Errorf("unknown observation kind %q (options: %s, %s, %s)", m.Kind, OBS_FULL, OBS_WINDOW, OBS_NOISY)
+	}
+	return nil
+}
+
+// 部分観測の環境が内部の環境に要求するメソッド (Environment・NonStationaryEnvが満たす)
+type gridEnv interface {
+	Env
+	AgentPosition() position.Position
+	PositionToState(pos position.Position) int
+	CellKind(pos position.Position) string
+	Height() int
+	Width() int
+}
+
+// エージェントが現在位置を直接観測できない部分観測(POMDP)の環境
+// Reset()・Step()は状態IDの代わりに観測IDを返すので，エージェントはQテーブルを観測IDで引く
+// 異なる状態が同じ観測IDになる(エイリアスする)場合，それらの状態は暗号化されたQテーブルの同じ行を共有する
+type PartialObsEnv struct {
+	env      gridEnv
+	Model    ObservationModel
+	patterns map[string]int // OBS_WINDOW: 周囲のマスのパターン → 観測ID
+	rng      *rand.Rand     // OBS_NOISY: 観測のノイズに使用する乱数生成器
+}
+
+// 内部の環境の湖に現れる周囲のマスのパターンに，行優先の順序で観測IDを割り当てて部分観測の環境を作成
+// 湖が変化して未知のパターンが現れた場合は，最後の観測ID (ObservationSpace()-1) にまとめる
+func NewPartialObsEnv(env gridEnv, model ObservationModel) *PartialObsEnv {
+	p := &PartialObsEnv{
+		env:      env,
+		Model:    model,
+		patterns: map[string]int{},
+		rng:      rand.New(rand.NewSource(0)),
+	}
+
+	if model.Kind == OBS_WINDOW {
+		for y := 0; y < env.Height(); y++ {
+			for x := 0; x < env.Width(); x++ {
+				pattern := p.window(position.Position{Y: y, X: x})
+				if _, ok := p.patterns[pattern]; !ok {
+					p.patterns[pattern] = len(p.patterns)
+				}
+			}
+		}
+	}
+
+	return p
+}
+
+// 内部の環境と観測のノイズの乱数のシードを設定
+func (p *PartialObsEnv) Seed(seed int64) {
+	if seeder, ok := p.env.(interface{ Seed(seed int64) }); ok {
+		seeder.Seed(seed)
+	}
+	p.rng = rand.New(rand.NewSource(utils.DeriveSeed(seed, utils.SEED_OBSERVATION))) // 内部の環境とは異なる乱数系列にする
+}
+
+// 観測IDの数
+func (p *PartialObsEnv) ObservationSpace() int {
+	switch p.Model.Kind {
+	case OBS_WINDOW:
+		return len(p.patterns) + 1 // 未知のパターンの分を加える
+	default:
+		return p.env.ObservationSpace()
+	}
+}
+
+func (p *PartialObsEnv) ActionSpace() int {
+	return p.env.ActionSpace()
+}
+
+func (p *PartialObsEnv) Reset() int {
+	p.env.Reset()
+	return p.observe(p.env.AgentPosition(), true)
+}
+
+// 行動を実行し，次の観測IDを返す (実際の状態IDはinfo[INFO_STATE]に格納する)
+func (p *PartialObsEnv) Step(action int) (int, int, bool, bool, map[string]interface{}) {
+	state, reward, terminated, truncated, info := p.env.Step(action)
+	if info == nil {
+		info = map[string]interface{}{}
+	}
+	info[INFO_STATE] = state
+
+	return p.observe(p.env.AgentPosition(), true), reward, terminated, truncated, info
+}
+
+// 地点の観測ID (noisyがfalseの場合はノイズを加えない)
+func (p *PartialObsEnv) observe(pos position.Position, noisy bool) int {
+	switch p.Model.Kind {
+	case OBS_WINDOW:
+		if id, ok := p.patterns[p.window(pos)]; ok {
+			return id
+		}
+		return len(p.patterns)
+	case OBS_NOISY:
+		if noisy && p.rng.Float64() < p.Model.Noise {
+			pos = p.randomNeighbor(pos)
+		}
+		return p.env.PositionToState(pos)
+	default:
+		return p.env.PositionToState(pos)
+	}
+}
+
+// 地点を中心とする周囲のマスの種類を行優先で並べた文字列
+func (p *PartialObsEnv) window(center position.Position) string {
+	var sb strings.Builder
+	for dy := -p.Model.Radius; dy <= p.Model.Radius; dy++ {
+		for dx := -p.Model.Radius; dx <= p.Model.Radius; dx++ {
+			pos := position.Position{Y: center.Y + dy, X: center.X + dx}
+			if pos.X < 0 || pos.X >= p.env.Width() || pos.Y < 0 || pos.Y >= p.env.Height() {
+				sb.WriteString(OUTSIDE_CELL)
+			} else {
+				sb.WriteString(p.env.CellKind(pos))
+			}
+		}
+	}
+	return sb.String()
+}
+
+// 上下左右に隣接する湖の中のマスから1つを等確率で選ぶ
+func (p *PartialObsEnv) randomNeighbor(pos position.Position) position.Position {
+	neighbors := []position.Position{}
+	for _, action := range FourWayActions {
+		neighbor := position.Position{Y: pos.Y + action.Move.Y, X: pos.X + action.Move.X}
+		if neighbor.X >= 0 && neighbor.X < p.env.Width() && neighbor.Y >= 0 && neighbor.Y < p.env.Height() {
+			neighbors = append(neighbors, neighbor)
+		}
+	}
+	return neighbors[p.rng.Intn(len(neighbors))]
+}
+
+// 観測IDで引くQテーブルを，状態IDで引くQテーブルに展開する (描画で使用する)
+// 各状態の行は，その状態でノイズなしに得られる観測IDの行とする
+func (p *PartialObsEnv) StateTable(qtable [][]float64) [][]float64 {
+	stateTable := make([][]float64, p.env.ObservationSpace())
+	for y := 0; y < p.env.Height(); y++ {
+		for x := 0; x < p.env.Width(); x++ {
+			pos := position.Position{Y: y, X: x}
+			stateTable[p.env.PositionToState(pos)] = qtable[p.observe(pos, false)]
+		}
+	}
+	return stateTable
+}
+
+// 同じ観測IDになる状態が他にもある状態の数
+func (p *PartialObsEnv) AliasedStates() int {
+	counts := map[int]int{}
+	for y := 0; y < p.env.Height(); y++ {
+		for x := 0; x < p.env.Width(); x++ {
+			counts[p.observe(position.Position{Y: y, X: x}, false)]++
+		}
+	}
+
+	aliased := 0
+	for _, count := range counts {
+		if count > 1 {
+			aliased += count
+		}
+	}
+	return aliased
+}
+
+var _ Env = (*PartialObsEnv)(nil)
@@ -0,0 +1,93 @@
+package environment
+
+import (
+	"fmt"
+	"pprlgoFrozenLake/frozenlake"
+	"pprlgoFrozenLake/utils"
+)
+
+// 環境が与える報酬の設定
+// 1ステップの報酬は 移動先に応じた報酬(Surface/Goal/Hole/Outside) + Step となる
+type RewardConfig struct {
+	Surface int `json:"surface"`  // 地面に移動した場合の報酬
+	Goal    int `json:"goal"`     // ゴールした場合の報酬
+	Hole    int `json:"hole"`     // 穴に移動した場合の報酬
+	Outside int `json:"outside"`  // 画面外に移動しようとした場合の報酬
+	ThinIce int `json:"thin_ice"` // 薄氷("t")に移動した場合の報酬 (終了状態にはならない)
+	Step    int `json:"step"`     // ステップ毎に加算される報酬 (負の値にするとステップ数に応じたペナルティになる)
+}
+
+var (
+	// これまでの実験で使用してきた報酬
+	DefaultRewardConfig = RewardConfig{
+		Surface: SURFACE_REWARD,
+		Goal:    GOAL_REWARD,
+		Hole:    HOLE_PENALTY,
+		Outside: OUTSIDE_PENALTY,
+		ThinIce: THIN_ICE_PENALTY,
+		Step:    STEP_PENALTY,
+	}
+
+	// GymnasiumのFrozenLakeと同じ疎な報酬 (ゴールした場合のみ1)
+	SparseRewardConfig = RewardConfig{
+		Goal: 1,
+	}
+)
+
+// 名前から報酬の設定を取得 (実験設定やコマンドライン引数で使用する)
+func RewardConfigByName(name string) (RewardConfig, error) {
+	switch name {
+	case "default":
+		return DefaultRewardConfig, nil
+	case "sparse":
+		return SparseRewardConfig, nil
+	default:
+		return RewardConfig{}, fmt.Errorf("unknown reward scheme %q (options: default, sparse)", name)
+	}
+}
+
+// 報酬から決まるQ値(割引報酬和)が暗号化時の固定小数点表現(utils.MapInteger)の範囲に収まるかを検査
+// Q値の絶対値は1ステップの報酬の絶対値の最大値|r|maxに対して|r|max/(1-γ)を超えない
+// tablesは和を取って行動価値とするQテーブルの数 (Double Q学習では2) で，その和も範囲に収まる必要がある
+func (r RewardConfig) Validate(gamma float64, tables int) error {
+	return r.validateReturns(r.maxAbsStepReward(), "", gamma, tables)
+}
+
+// 湖のマス毎の報酬(FrozenLake.CellRewards)も含めて，Q値が表現可能な範囲に収まるかを検査
+func (r RewardConfig) ValidateLake(lake frozenlake.FrozenLake, gamma float64, tables int) error {
+	maxAbs := r.maxAbsStepReward()
+	for _, reward := range lake.CellRewards {
+		maxAbs = max(maxAbs, abs(reward+r.Step))
+	}
+	return r.validateReturns(maxAbs, " and cell rewards", gamma, tables)
+}
+
+// 1ステップで得られる報酬の絶対値の最大値
+func (r RewardConfig) maxAbsStepReward() int {
+	maxAbs := 0
+	for _, reward := range []int{r.Surface, r.Goal, r.Hole, r.Outside, r.ThinIce} {
+		maxAbs = max(maxAbs, abs(reward+r.Step))
+	}
+	return maxAbs
+}
+
+func (r RewardConfig) validateReturns(maxAbs int, source string, gamma float64, tables int) error {
+	if gamma < 0 || gamma >= 1 {
+		return fmt.Errorf("discount factor must be in [0, 1), got %f", gamma)
+	}
+
+	bound := float64(tables) * float64(maxAbs) / (1 - gamma)
+	if !utils.IsEncodable(bound) {
+		return fmt.Errorf("rewards%s up to |%d| per step (including step reward %d) give Q values up to ±%.1f with gamma %.2f (summed over %d table(s): ±%.1f), which is outside the encodable range [%.0f, %.0f]",
+			source, maxAbs, r.Step, float64(maxAbs)/(1-gamma), gamma, tables, bound, -utils.MaxEncodableValue(), utils.MaxEncodableValue())
+	}
+
+	return nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,151 @@
+package environment
+
+import (
+	"fmt"
+	"math"
+	"pprlgoFrozenLake/position"
+	"strconv"
+	"strings"
+)
+
+// 滑る床(slippery)の遷移モデル
+// 意図した方向に進む確率，直交方向に滑る確率，逆方向に滑る確率の合計は1となる
+type SlipModel struct {
+	IntendedProb      float64 `json:"intended"`      // 意図した方向に進む確率
+	PerpendicularProb float64 `json:"perpendicular"` // 直交方向に滑る確率 (左右の2方向に等確率で振り分ける)
+	OppositeProb      float64 `json:"opposite"`      // 逆方向に滑る確率
+}
+
+var (
+	// 滑らない (決定的な遷移)
+	NoSlip = SlipModel{
+		IntendedProb: 1,
+	}
+
+	// GymnasiumのFrozenLake (is_slippery=True) と同じ遷移確率
+	// 意図した方向と直交する2方向にそれぞれ1/3の確率で進む
+	GymSlippery = SlipModel{
+		IntendedProb:      1.0 / 3.0,
+		PerpendicularProb: 2.0 / 3.0,
+	}
+)
+
+// 確率の計算で生じる浮動小数点の丸め誤差の許容値
+const slipTolerance = 1e-9
+
+// 滑りモデルのプリセット名 (コマンドライン引数で使用する)
+const (
+	SLIP_NONE = "none" // NoSlip
+	SLIP_GYM  = "gym"  // GymSlippery
+)
+
+// 意図した方向に進む確率を指定し，残りを直交方向に割り当てた滑りモデルを作成
+func NewSlipModel(intendedProb float64) SlipModel {
+	return NewSlipModelWithOpposite(intendedProb, 0)
+}
+
+// 意図した方向・逆方向に進む確率を指定し，残りを直交方向に割り当てた滑りモデルを作成
+// (0.9,0.1のように残りが0になる場合，丸め誤差でわずかに負になった値は0とする)
+func NewSlipModelWithOpposite(intendedProb float64, oppositeProb float64) SlipModel {
+	perpendicularProb := 1 - intendedProb - oppositeProb
+	if math.Abs(perpendicularProb) < slipTolerance {
+		perpendicularProb = 0
+	}
+	return SlipModel{
+		IntendedProb:      intendedProb,
+		PerpendicularProb: perpendicularProb,
+		OppositeProb:      oppositeProb,
+	}
+}
+
+// 文字列から滑りモデルを作成 (コマンドライン引数で使用する)
+// プリセット名 (none, gym)，意図した方向の確率 ("0.8")，意図した方向と逆方向の確率 ("0.8,0.1") のいずれかを受け付ける
+func ParseSlipModel(text string) (SlipModel, error) {
+	switch text {
+	case SLIP_NONE:
+		return NoSlip, nil
+	case SLIP_GYM:
+		return GymSlippery, nil
+	}
+
+	fields := strings.Split(text, ",")
+	if len(fields) > 2 {
+		return SlipModel{}, fmt.Errorf("expected %s, %s, <intended> or <intended>,<opposite>, got %q", SLIP_NONE, SLIP_GYM, text)
+	}
+	probs := make([]float64, 2)
+	for i, field := range fields {
+		prob, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return SlipModel{}, fmt.Errorf("invalid probability %q: %w", field, err)
+		}
+		probs[i] = prob
+	}
+
+	slip := NewSlipModelWithOpposite(probs[0], probs[1])
+	if err := slip.Validate(); err != nil {
+		return SlipModel{}, err
+	}
+	return slip, nil
+}
+
+func (s SlipModel) Validate() error {
+	if s.IntendedProb < 0 || s.PerpendicularProb < 0 || s.OppositeProb < 0 {
+		return fmt.Errorf("slip probabilities must be non-negative: %+v", s)
+	}
+
+	sum := s.IntendedProb + s.PerpendicularProb + s.OppositeProb
+	if math.Abs(sum-1) > slipTolerance {
+		return fmt.Errorf("slip probabilities must sum to 1 (got %f): %+v", sum, s)
+	}
+
+	return nil
+}
+
+// 滑らないモデルかどうか (乱数を消費せずに済ませるために使用する)
+func (s SlipModel) IsDeterministic() bool {
+	return s.IntendedProb >= 1
+}
+
+// 滑りモデルに従って実際に実行される行動を決定
+func (e *Environment) slipAction(action int) int {
+	if e.Slip.IsDeterministic() {
+		return action
+	}
+
+	r := e.rng.Float64()
+	switch {
+	case r < e.Slip.IntendedProb:
+		return action
+	case r < e.Slip.IntendedProb+e.Slip.PerpendicularProb/2:
+		return e.perpendicularActions(action)[0]
+	case r < e.Slip.IntendedProb+e.Slip.PerpendicularProb:
+		return e.perpendicularActions(action)[1]
+	default:
+		return e.oppositeAction(action)
+	}
+}
+
+// 移動方向を90度回転させた2つの行動 (IDが小さい順)
+// 行動の集合に含まれない方向の場合 (その場に留まる行動など) は元の行動のままとする
+func (e *Environment) perpendicularActions(action int) [2]int {
+	move := e.Actions[action].Move
+	first := e.Actions.indexOf(position.Position{Y: -move.X, X: move.Y})
+	second := e.Actions.indexOf(position.Position{Y: move.X, X: -move.Y})
+	if first < 0 || second < 0 {
+		return [2]int{action, action}
+	}
+	if first > second {
+		first, second = second, first
+	}
+	return [2]int{first, second}
+}
+
+// 移動方向を反転させた行動 (行動の集合に含まれない場合は元の行動のままとする)
+func (e *Environment) oppositeAction(action int) int {
+	move := e.Actions[action].Move
+	opposite := e.Actions.indexOf(position.Position{Y: -move.Y, X: -move.X})
+	if opposite < 0 {
+		return action
+	}
+	return opposite
+}
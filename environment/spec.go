@@ -0,0 +1,47 @@
+package environment
+
+import "pprlgoFrozenLake/frozenlake"
+
+// 環境を再構築するための設定 (軌跡の記録と再生で使用する)
+type Spec struct {
+	Lake     string       `json:"lake"` // 湖 ("SFHG"形式)
+	Rewards  RewardConfig `json:"rewards"`
+	Actions  ActionSet    `json:"actions"`
+	Slip     SlipModel    `json:"slip"`
+	MaxSteps int          `json:"max_steps"`
+	Windy    bool         `json:"windy,omitempty"`
+	Seed     int64        `json:"seed"`               // 最後に設定したシード (Seed()の直後に取得すれば，以降の遷移を再現できる)
+	Schedule Schedule     `json:"schedule,omitempty"` // 非定常な環境の湖の変化の予定 (NonStationaryEnvの場合のみ)
+}
+
+// 現在の環境の設定を取得
+func (e *Environment) Spec() Spec {
+	return Spec{
+		Lake:     e.frozenLake.String(),
+		Rewards:  e.rewardCfg,
+		Actions:  e.Actions,
+		Slip:     e.Slip,
+		MaxSteps: e.MaxSteps,
+		Windy:    e.Windy,
+		Seed:     e.seed,
+	}
+}
+
+// 設定から環境を再構築
+func NewEnvironmentFromSpec(spec Spec) (*Environment, error) {
+	lake, err := frozenlake.ParseLakeMap(spec.Lake)
+	if err != nil {
+		return nil, err
+	}
+
+	env := NewEnvironment(lake, spec.Rewards)
+	if len(spec.Actions) > 0 {
+		env.Actions = spec.Actions
+	}
+	env.Slip = spec.Slip
+	env.MaxSteps = spec.MaxSteps
+	env.Windy = spec.Windy
+	env.Seed(spec.Seed)
+
+	return env, nil
+}
@@ -0,0 +1,93 @@
+package environment
+
+import "pprlgoFrozenLake/utils"
+
+// 自動リセットされたコピーのinfoに，リセット前の最後の状態ID (int) を格納するキー
+const INFO_FINAL_OBSERVATION = "final_observation"
+
+// 独立したN個の環境のコピーを同時に1ステップずつ進めるベクトル化環境
+// エピソードが終了したコピーは自動的にリセットされる
+type VecEnv struct {
+	envs []Env
+}
+
+// 同じ状態数・行動数を持つ環境のコピーからベクトル化環境を作成
+func NewVecEnv(envs []Env) *VecEnv {
+	for _, env := range envs[1:] {
+		if env.ObservationSpace() != envs[0].ObservationSpace() || env.ActionSpace() != envs[0].ActionSpace() {
+			panic("environment: all copies of a VecEnv must have the same observation and action spaces")
+		}
+	}
+	return &VecEnv{envs: envs}
+}
+
+// newEnvで作成したn個のコピーからベクトル化環境を作成
+func NewVecEnvN(n int, newEnv func() Env) *VecEnv {
+	envs := make([]Env, n)
+	for i := range envs {
+		envs[i] = newEnv()
+	}
+	return NewVecEnv(envs)
+}
+
+// コピーの数
+func (v *VecEnv) Num() int {
+	return len(v.envs)
+}
+
+// 各コピーにbaseSeedとコピーの番号から導出した異なるシード (utils.DeriveSeed(baseSeed, i)) を設定
+func (v *VecEnv) Seed(baseSeed int64) {
+	for i, env := range v.envs {
+		if seeder, ok := env.(interface{ Seed(seed int64) }); ok {
+			seeder.Seed(utils.DeriveSeed(baseSeed, int64(i)))
+		}
+	}
+}
+
+func (v *VecEnv) ObservationSpace() int {
+	return v.envs[0].ObservationSpace()
+}
+
+func (v *VecEnv) ActionSpace() int {
+	return v.envs[0].ActionSpace()
+}
+
+// i番目のコピー
+func (v *VecEnv) Env(i int) Env {
+	return v.envs[i]
+}
+
+// 全てのコピーをリセットし，初期状態のIDを返す
+func (v *VecEnv) Reset() []int {
+	observations := make([]int, len(v.envs))
+	for i, env := range v.envs {
+		observations[i] = env.Reset()
+	}
+	return observations
+}
+
+// 全てのコピーで1ステップずつ行動を実行する (actions[i]はi番目のコピーの行動)
+// エピソードが終了(terminated/truncated)したコピーはリセットされ，返す状態IDはリセット後の初期状態になる
+// その場合，終了時の状態IDはinfos[i][INFO_FINAL_OBSERVATION]に格納する
+func (v *VecEnv) Step(actions []int) ([]int, []int, []bool, []bool, []map[string]interface{}) {
+	n := len(v.envs)
+	observations := make([]int, n)
+	rewards := make([]int, n)
+	terminateds := make([]bool, n)
+	truncateds := make([]bool, n)
+	infos := make([]map[string]interface{}, n)
+
+	for i, env := range v.envs {
+		observations[i], rewards[i], terminateds[i], truncateds[i], infos[i] = env.Step(actions[i])
+
+		if terminateds[i] || truncateds[i] {
+			if infos[i] == nil {
+				infos[i] = map[string]interface{}{}
+			}
+			infos[i][INFO_FINAL_OBSERVATION] = observations[i]
+			observations[i] = env.Reset()
+		}
+	}
+
+	return observations, rewards, terminateds, truncateds, infos
+}
@@ -0,0 +1,49 @@
+package environment
+
+import "pprlgoFrozenLake/position"
+
+// 風による押し流しを適用した移動先 (Windyがfalseの場合や湖に風がない場合はnextStateのまま)
+// SuttonとBartoのwindy gridworldと同様に，移動前のマスの列・行の風の強さだけ1マスずつ押し流す
+// 湖の端では止まり，途中で穴やゴールに入った場合はそこで止まる
+func (e *Environment) applyWind(state position.Position, nextState position.Position) position.Position {
+	if !e.Windy {
+		return nextState
+	}
+
+	drift := position.Position{}
+	if len(e.frozenLake.ColumnWind) > 0 {
+		drift.Y = -e.frozenLake.ColumnWind[state.X] // 正の値は上向き
+	}
+	if len(e.frozenLake.RowWind) > 0 {
+		drift.X = e.frozenLake.RowWind[state.Y] // 正の値は右向き
+	}
+
+	pushed := nextState
+	for drift != (position.Position{}) {
+		if e.isHole[pushed] || e.isGoal[pushed] {
+			break
+		}
+
+		step := position.Position{Y: sign(drift.Y), X: sign(drift.X)}
+		candidate := position.Position{Y: pushed.Y + step.Y, X: pushed.X + step.X}
+		if candidate.X < 0 || candidate.X >= e.Width() || candidate.Y < 0 || candidate.Y >= e.Height() {
+			break
+		}
+
+		pushed = candidate
+		drift = position.Position{Y: drift.Y - step.Y, X: drift.X - step.X}
+	}
+
+	return pushed
+}
+
+func sign(v int) int {
+	switch {
+	case v > 0:
+		return 1
+	case v < 0:
+		return -1
+	default:
+		return 0
+	}
+}
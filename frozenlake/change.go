@@ -0,0 +1,73 @@
+package frozenlake
+
+import (
+	"fmt"
+	"pprlgoFrozenLake/position"
+)
+
+// 湖の変化の種類
+const (
+	CHANGE_OPEN_HOLE   = "open_hole"   // 指定したマスを穴にする
+	CHANGE_CLOSE_HOLE  = "close_hole"  // 指定したマスを地面にする
+	CHANGE_TOGGLE_HOLE = "toggle_hole" // 指定したマスが穴なら地面に，地面なら穴にする
+	CHANGE_MOVE_GOAL   = "move_goal"   // Goal番目のゴールを指定したマスに移動する
+)
+
+// 湖に対する1回の変化
+type LakeChange struct {
+	Kind string `json:"kind"` // 変化の種類 (CHANGE_*)
+	X    int    `json:"x"`    // 変化させるマス (move_goalでは移動先)
+	Y    int    `json:"y"`
+	Goal int    `json:"goal,omitempty"` // move_goalで移動するゴールの番号 (GoalPositions()の添字)
+}
+
+func (c LakeChange) String() string {
+	if c.Kind == CHANGE_MOVE_GOAL {
+		return fmt.Sprintf("%s #%d to (%d, %d)", c.Kind, c.Goal, c.X, c.Y)
+	}
+	return fmt.Sprintf("%s at (%d, %d)", c.Kind, c.X, c.Y)
+}
+
+// 変化を適用した新しい湖を返す (元の湖は変更しない)
+// 適用後の湖の整合性はValidate()で別途検査すること
+func (c LakeChange) Apply(l FrozenLake) (FrozenLake, error) {
+	pos := position.Position{Y: c.Y, X: c.X}
+	if !l.inBounds(pos) {
+		return FrozenLake{}, fmt.Errorf("change %s is out of bounds for a %dx%d lake", c, l.Width, l.Height)
+	}
+
+	changed := l.Clone()
+	switch c.Kind {
+	case CHANGE_OPEN_HOLE:
+		changed.LakeMap[c.Y][c.X] = "x"
+	case CHANGE_CLOSE_HOLE:
+		changed.LakeMap[c.Y][c.X] = "o"
+	case CHANGE_TOGGLE_HOLE:
+		if changed.LakeMap[c.Y][c.X] == "x" {
+			changed.LakeMap[c.Y][c.X] = "o"
+		} else {
+			changed.LakeMap[c.Y][c.X] = "x"
+		}
+	case CHANGE_MOVE_GOAL:
+		goals := changed.GoalPositions()
+		if c.Goal < 0 || c.Goal >= len(goals) {
+			return FrozenLake{}, fmt.Errorf("change %s refers to goal #%d, but the lake has %d goals", c, c.Goal, len(goals))
+		}
+		// ゴール毎に指定された報酬はゴールと一緒に移動する
+		old := goals[c.Goal]
+		if reward, ok := changed.CellRewards[old]; ok {
+			delete(changed.CellRewards, old)
+			changed.CellRewards[pos] = reward
+		}
+		if len(changed.Goals) > 0 {
+			changed.Goals[c.Goal] = pos
+			changed.GoalPos = changed.Goals[0]
+		} else {
+			changed.GoalPos = pos
+		}
+	default:
+		return FrozenLake{}, fmt.Errorf("unknown change kind %q (options: %s, %s, %s, %s)", c.Kind, CHANGE_OPEN_HOLE, CHANGE_CLOSE_HOLE, CHANGE_TOGGLE_HOLE, CHANGE_MOVE_GOAL)
+	}
+
+	return changed, nil
+}
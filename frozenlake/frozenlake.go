@@ -3,11 +3,58 @@ package frozenlake
 import "pprlgoFrozenLake/position"
 
 type FrozenLake struct {
-	Width    int               // 湖の幅
-	Height   int               // 湖の高さ
-	LakeMap  [][]string        // 湖の状態 ("o": 地面, "x": 穴)
-	StartPos position.Position // スタート地点
-	GoalPos  position.Position // ゴール地点
+	Width       int                       // 湖の幅
+	Height      int                       // 湖の高さ
+	LakeMap     [][]string                // 湖の状態 ("o": 地面, "x": 穴, "t": 薄氷 (ペナルティのある地面))
+	StartPos    position.Position         // スタート地点
+	GoalPos     position.Position         // ゴール地点 (ゴールが複数ある場合は最初のゴール)
+	Goals       []position.Position       // 全てのゴール地点 (空の場合はGoalPosのみ)
+	CellRewards map[position.Position]int // マス毎の報酬 (指定したマスでは報酬の設定の値を上書きする．ゴール毎の報酬もここで指定する)
+	ColumnWind  []int                     // 列ごとの風の強さ (移動後に上向きに押し流すマス数．負の値は下向き．空の場合は風なし)
+	RowWind     []int                     // 行ごとの風の強さ (移動後に右向きに押し流すマス数．負の値は左向き．空の場合は風なし)
+}
+
+// 全てのゴール地点
+func (l FrozenLake) GoalPositions() []position.Position {
+	if len(l.Goals) == 0 {
+		return []position.Position{l.GoalPos}
+	}
+	return l.Goals
+}
+
+// 指定した地点がゴール地点かどうか
+func (l FrozenLake) IsGoal(pos position.Position) bool {
+	for _, goal := range l.GoalPositions() {
+		if pos == goal {
+			return true
+		}
+	}
+	return false
+}
+
+// 湖の複製 (LakeMap・Goals・CellRewardsも複製し，元の湖と共有しない)
+func (l FrozenLake) Clone() FrozenLake {
+	clone := l
+	clone.LakeMap = make([][]string, len(l.LakeMap))
+	for y, row := range l.LakeMap {
+		clone.LakeMap[y] = append([]string{}, row...)
+	}
+	if l.Goals != nil {
+		clone.Goals = append([]position.Position{}, l.Goals...)
+	}
+	if l.ColumnWind != nil {
+		clone.ColumnWind = append([]int{}, l.ColumnWind...)
+	}
+	if l.RowWind != nil {
+		clone.RowWind = append([]int{}, l.RowWind...)
+	}
+	if l.CellRewards != nil {
+		clone.CellRewards = make(map[position.Position]int, len(l.CellRewards))
+		for pos, reward := range l.CellRewards {
+			clone.CellRewards[pos] = reward
+		}
+	}
+	return clone
 }
 
 var (
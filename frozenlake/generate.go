@@ -0,0 +1,103 @@
+package frozenlake
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"pprlgoFrozenLake/position"
+)
+
+// ゴールに到達可能なマップが得られるまでに再生成する最大回数
+const MAX_GENERATE_ATTEMPTS = 10000
+
+// 上下左右の隣接マスへの移動 (到達可能性の探索で使用する)
+var neighborMoves = []position.Position{{Y: -1, X: 0}, {Y: 1, X: 0}, {Y: 0, X: -1}, {Y: 0, X: 1}}
+
+// 幅・高さ・穴の密度・シードを指定してランダムな湖を生成
+// スタート地点は左上，ゴール地点は右下に固定し，スタートからゴールに到達可能であることを保証する
+func GenerateLake(width int, height int, holeDensity float64, seed int64) (FrozenLake, error) {
+	if width <= 0 || height <= 0 || width*height < 2 {
+		return FrozenLake{}, fmt.Errorf("invalid lake size %dx%d: the lake needs at least two cells", width, height)
+	}
+	if holeDensity < 0 || holeDensity >= 1 {
+		return FrozenLake{}, fmt.Errorf("hole density must be in [0, 1), got %f", holeDensity)
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	startPos := position.Position{X: 0, Y: 0}
+	goalPos := position.Position{X: width - 1, Y: height - 1}
+
+	for attempt := 0; attempt < MAX_GENERATE_ATTEMPTS; attempt++ {
+		lakeMap := make([][]string, height)
+		for y := range lakeMap {
+			lakeMap[y] = make([]string, width)
+			for x := range lakeMap[y] {
+				pos := position.Position{Y: y, X: x}
+				// スタート地点とゴール地点は必ず地面にする
+				if pos != startPos && pos != goalPos && rng.Float64() < holeDensity {
+					lakeMap[y][x] = "x"
+				} else {
+					lakeMap[y][x] = "o"
+				}
+			}
+		}
+
+		lake := FrozenLake{
+			Width:    width,
+			Height:   height,
+			LakeMap:  lakeMap,
+			StartPos: startPos,
+			GoalPos:  goalPos,
+		}
+		if IsGoalReachable(lake) {
+			return lake, nil
+		}
+	}
+
+	return FrozenLake{}, errors.New("failed to generate a solvable lake; try a lower hole density")
+}
+
+// スタート地点からいずれかのゴール地点まで穴を通らずに到達できるかを幅優先探索で判定
+func IsGoalReachable(lake FrozenLake) bool {
+	distances := shortestDistances(lake, lake.StartPos)
+	for _, goal := range lake.GoalPositions() {
+		if _, ok := distances[goal]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 指定した地点から到達可能な各地点までの最短ステップ数を幅優先探索で求める
+// 穴とゴール地点は終了状態のため，そこから先には進まない
+func shortestDistances(lake FrozenLake, from position.Position) map[position.Position]int {
+	distances := map[position.Position]int{from: 0}
+	queue := []position.Position{from}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if lake.isTerminal(current) {
+			continue
+		}
+
+		for _, move := range neighborMoves {
+			next := position.Position{Y: current.Y + move.Y, X: current.X + move.X}
+			if !lake.inBounds(next) {
+				continue
+			}
+			if _, visited := distances[next]; visited {
+				continue
+			}
+			distances[next] = distances[current] + 1
+			queue = append(queue, next)
+		}
+	}
+
+	return distances
+}
+
+// 穴またはゴール地点 (エピソードが終了する地点) かどうか
+func (l FrozenLake) isTerminal(pos position.Position) bool {
+	return l.LakeMap[pos.Y][pos.X] == "x" || l.IsGoal(pos)
+}
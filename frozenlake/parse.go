@@ -0,0 +1,224 @@
+package frozenlake
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"pprlgoFrozenLake/position"
+	"strings"
+)
+
+// GymnasiumのFrozenLakeで使われるマップの文字
+const (
+	START_CELL   = 'S' // スタート地点 (地面)
+	FROZEN_CELL  = 'F' // 地面
+	HOLE_CELL    = 'H' // 穴
+	GOAL_CELL    = 'G' // ゴール地点 (地面)
+	THIN_ICE     = 'T' // 薄氷 (ペナルティのある地面．このリポジトリ独自の拡張)
+	COMMENT_LINE = '#' // コメント行
+)
+
+// マス毎の報酬を指定する行 (例: "reward 3 0 5" は X=3, Y=0 のマスの報酬を5にする)
+const REWARD_DIRECTIVE = "reward"
+
+// 列・行ごとの風の強さを指定する行
+// "wind col 0 0 1 1 0" は列ごとに上向きに押し流すマス数，"wind row 0 1 0" は行ごとに右向きに押し流すマス数 (負の値は逆向き)
+const (
+	WIND_DIRECTIVE = "wind"
+	WIND_COLUMN    = "col"
+	WIND_ROW       = "row"
+)
+
+// マップファイルを読み込んで湖を作成
+func LoadLakeMap(path string) (FrozenLake, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return FrozenLake{}, err
+	}
+
+	lake, err := ParseLakeMap(string(data))
+	if err != nil {
+		return FrozenLake{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return lake, nil
+}
+
+// "SFHG" 形式の文字列から湖を作成
+// 行は改行またはカンマで区切る (例: "SFFF,FHFH,FFFH,HFFG")
+// 空行と '#' で始まる行は無視する (コメントや指定の行の中のカンマは行の区切りとみなさない)
+// ゴール(G)は複数置くことができ，"reward X Y VALUE" の行でゴールや任意のマスの報酬を個別に指定できる
+// "wind col ..." / "wind row ..." の行で列・行ごとの風の強さを指定できる
+func ParseLakeMap(text string) (FrozenLake, error) {
+	rows := []string{}
+	directives := []string{}
+	windDirectives := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == COMMENT_LINE {
+			continue
+		}
+		if strings.HasPrefix(line, REWARD_DIRECTIVE+" ") {
+			directives = append(directives, line)
+			continue
+		}
+		if strings.HasPrefix(line, WIND_DIRECTIVE+" ") {
+			windDirectives = append(windDirectives, line)
+			continue
+		}
+		// 1行に複数の行をカンマ区切りで書いたインラインのマップ
+		for _, row := range strings.Split(line, ",") {
+			row = strings.TrimSpace(row)
+			if row != "" {
+				rows = append(rows, row)
+			}
+		}
+	}
+
+	if len(rows) == 0 {
+		return FrozenLake{}, errors.New("map is empty")
+	}
+
+	width := len(rows[0])
+	height := len(rows)
+	lakeMap := make([][]string, height)
+	starts := []position.Position{}
+	goals := []position.Position{}
+
+	for y, row := range rows {
+		if len(row) != width {
+			return FrozenLake{}, fmt.Errorf("row %d has width %d, expected %d (rows must be rectangular)", y, len(row), width)
+		}
+
+		lakeMap[y] = make([]string, width)
+		for x, cell := range []byte(row) {
+			pos := position.Position{Y: y, X: x}
+			switch cell {
+			case START_CELL:
+				lakeMap[y][x] = "o"
+				starts = append(starts, pos)
+			case FROZEN_CELL:
+				lakeMap[y][x] = "o"
+			case HOLE_CELL:
+				lakeMap[y][x] = "x"
+			case GOAL_CELL:
+				lakeMap[y][x] = "o"
+				goals = append(goals, pos)
+			case THIN_ICE:
+				lakeMap[y][x] = "t"
+			default:
+				return FrozenLake{}, fmt.Errorf("unknown cell %q at %s (expected one of S, F, H, G, T)", cell, pos)
+			}
+		}
+	}
+
+	if len(starts) != 1 {
+		return FrozenLake{}, fmt.Errorf("map must have exactly one start (S), found %d", len(starts))
+	}
+	if len(goals) == 0 {
+		return FrozenLake{}, errors.New("map must have at least one goal (G)")
+	}
+
+	cellRewards := map[position.Position]int{}
+	for _, directive := range directives {
+		var pos position.Position
+		var reward int
+		if _, err := fmt.Sscanf(directive, REWARD_DIRECTIVE+" %d %d %d", &pos.X, &pos.Y, &reward); err != nil {
+			return FrozenLake{}, fmt.Errorf("invalid directive %q (expected \"%s X Y VALUE\"): %w", directive, REWARD_DIRECTIVE, err)
+		}
+		if pos.X < 0 || pos.X >= width || pos.Y < 0 || pos.Y >= height {
+			return FrozenLake{}, fmt.Errorf("directive %q is out of bounds for a %dx%d lake", directive, width, height)
+		}
+		cellRewards[pos] = reward
+	}
+
+	lake := FrozenLake{
+		Width:    width,
+		Height:   height,
+		LakeMap:  lakeMap,
+		StartPos: starts[0],
+		GoalPos:  goals[0],
+	}
+	// 従来のマップとの互換性のため，ゴールが1つで報酬の指定がない場合はGoalPosのみを設定する
+	if len(goals) > 1 {
+		lake.Goals = goals
+	}
+	if len(cellRewards) > 0 {
+		lake.CellRewards = cellRewards
+	}
+
+	for _, directive := range windDirectives {
+		fields := strings.Fields(directive)
+		if len(fields) < 2 || (fields[1] != WIND_COLUMN && fields[1] != WIND_ROW) {
+			return FrozenLake{}, fmt.Errorf("invalid directive %q (expected \"%s %s ...\" or \"%s %s ...\")", directive, WIND_DIRECTIVE, WIND_COLUMN, WIND_DIRECTIVE, WIND_ROW)
+		}
+
+		expected := width
+		if fields[1] == WIND_ROW {
+			expected = height
+		}
+		if len(fields)-2 != expected {
+			return FrozenLake{}, fmt.Errorf("directive %q has %d values, but the lake has %d %ss", directive, len(fields)-2, expected, fields[1])
+		}
+
+		strengths := make([]int, expected)
+		for i, field := range fields[2:] {
+			if _, err := fmt.Sscanf(field, "%d", &strengths[i]); err != nil {
+				return FrozenLake{}, fmt.Errorf("invalid directive %q: %w", directive, err)
+			}
+		}
+		if fields[1] == WIND_COLUMN {
+			lake.ColumnWind = strengths
+		} else {
+			lake.RowWind = strengths
+		}
+	}
+
+	return lake, nil
+}
+
+// 湖を "SFHG" 形式の文字列に変換 (1行が湖の1行に対応する)
+func (l FrozenLake) String() string {
+	var sb strings.Builder
+	for y, row := range l.LakeMap {
+		for x, cell := range row {
+			pos := position.Position{Y: y, X: x}
+			switch {
+			case pos == l.StartPos:
+				sb.WriteByte(START_CELL)
+			case l.IsGoal(pos):
+				sb.WriteByte(GOAL_CELL)
+			case cell == "x":
+				sb.WriteByte(HOLE_CELL)
+			case cell == "t":
+				sb.WriteByte(THIN_ICE)
+			default:
+				sb.WriteByte(FROZEN_CELL)
+			}
+		}
+		sb.WriteByte('\n')
+	}
+
+	// マス毎の報酬は行優先の順序で出力する
+	for y := 0; y < l.Height; y++ {
+		for x := 0; x < l.Width; x++ {
+			if reward, ok := l.CellRewards[position.Position{Y: y, X: x}]; ok {
+				fmt.Fprintf(&sb, "%s %d %d %d\n", REWARD_DIRECTIVE, x, y, reward)
+			}
+		}
+	}
+
+	writeWind := func(axis string, strengths []int) {
+		if len(strengths) == 0 {
+			return
+		}
+		sb.WriteString(WIND_DIRECTIVE + " " + axis)
+		for _, strength := range strengths {
+			fmt.Fprintf(&sb, " %d", strength)
+		}
+		sb.WriteByte('\n')
+	}
+	writeWind(WIND_COLUMN, l.ColumnWind)
+	writeWind(WIND_ROW, l.RowWind)
+	return sb.String()
+}
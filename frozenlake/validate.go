@@ -0,0 +1,212 @@
+package frozenlake
+
+import (
+	"fmt"
+	"pprlgoFrozenLake/position"
+	"strings"
+)
+
+// FrozenLakeの定義に含まれる1つの不整合
+type ValidationError struct {
+	Field   string             // 不整合のあるフィールド (例: "LakeMap", "StartPos")
+	Pos     *position.Position // 不整合のある地点 (地点に依存しない場合はnil)
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Pos != nil {
+		return fmt.Sprintf("%s at %s: %s", e.Field, *e.Pos, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// Validate()で見つかった全ての不整合
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid frozen lake (%d problems): %s", len(errs), strings.Join(messages, "; "))
+}
+
+// 湖の定義が整合しているかを検査し，不整合があればValidationErrorsを返す
+func (l FrozenLake) Validate() error {
+	errs := ValidationErrors{}
+	add := func(field string, pos *position.Position, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: field, Pos: pos, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if l.Width <= 0 || l.Height <= 0 {
+		add("Width/Height", nil, "size must be positive, got %dx%d", l.Width, l.Height)
+		return errs
+	}
+
+	// LakeMapの大きさとセルの記号
+	if len(l.LakeMap) != l.Height {
+		add("LakeMap", nil, "has %d rows, but Height is %d", len(l.LakeMap), l.Height)
+	}
+	for y, row := range l.LakeMap {
+		if len(row) != l.Width {
+			add("LakeMap", nil, "row %d has %d cells, but Width is %d", y, len(row), l.Width)
+		}
+		for x, cell := range row {
+			if cell != "o" && cell != "x" && cell != "t" {
+				pos := position.Position{Y: y, X: x}
+				add("LakeMap", &pos, "unknown cell symbol %q (expected \"o\", \"x\" or \"t\")", cell)
+			}
+		}
+	}
+
+	// スタート地点とゴール地点
+	startPos := l.StartPos
+	if !l.inBounds(startPos) {
+		add("StartPos", &startPos, "out of bounds for a %dx%d lake", l.Width, l.Height)
+	}
+	if len(l.Goals) > 0 && l.Goals[0] != l.GoalPos {
+		goalPos := l.GoalPos
+		add("GoalPos", &goalPos, "must be the first of Goals %v", l.Goals)
+	}
+	for _, goal := range l.GoalPositions() {
+		goalPos := goal
+		if !l.inBounds(goalPos) {
+			add("Goals", &goalPos, "out of bounds for a %dx%d lake", l.Width, l.Height)
+		}
+		if startPos == goalPos {
+			add("StartPos", &startPos, "start and goal are the same cell")
+		}
+	}
+	for pos := range l.CellRewards {
+		cellPos := pos
+		if !l.inBounds(cellPos) {
+			add("CellRewards", &cellPos, "out of bounds for a %dx%d lake", l.Width, l.Height)
+		}
+	}
+
+	if len(l.ColumnWind) > 0 && len(l.ColumnWind) != l.Width {
+		add("ColumnWind", nil, "has %d columns, but Width is %d", len(l.ColumnWind), l.Width)
+	}
+	if len(l.RowWind) > 0 && len(l.RowWind) != l.Height {
+		add("RowWind", nil, "has %d rows, but Height is %d", len(l.RowWind), l.Height)
+	}
+
+	// ここまでに不整合があるとマップを参照できないので，到達可能性は検査しない
+	// 到達可能性は風のない場合について検査する (風の強さによっては風のある湖では到達できないことがある)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	if l.LakeMap[startPos.Y][startPos.X] == "x" {
+		add("StartPos", &startPos, "start is on a hole")
+	}
+	for _, goal := range l.GoalPositions() {
+		goalPos := goal
+		if l.LakeMap[goalPos.Y][goalPos.X] == "x" {
+			add("Goals", &goalPos, "goal is on a hole")
+		}
+	}
+	if len(errs) == 0 && !IsGoalReachable(l) {
+		add("Goals", nil, "no goal is reachable from the start")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (l FrozenLake) inBounds(pos position.Position) bool {
+	return pos.X >= 0 && pos.X < l.Width && pos.Y >= 0 && pos.Y < l.Height
+}
+
+// 湖の静的解析の結果
+type Analysis struct {
+	ShortestPathLength int                 // スタートから最も近いゴールまでの最短ステップ数 (到達不能な場合は-1)
+	ReachableStates    int                 // スタートから到達可能な状態数 (穴とゴールを含む)
+	HoleStates         int                 // スタートから到達可能な穴の数
+	DeadEndStates      []position.Position // スタートから到達可能だが，そこからゴールに到達できない地面
+	UnreachableGoals   []position.Position // スタートから到達できないゴール
+}
+
+// 湖を解析して最短経路長・到達可能な状態数・行き止まりの状態を求める
+// Validate()が成功した湖に対して呼び出すこと
+func (l FrozenLake) Analyze() Analysis {
+	fromStart := shortestDistances(l, l.StartPos)
+	toGoal := l.statesReachingGoal()
+
+	analysis := Analysis{
+		ShortestPathLength: -1,
+		ReachableStates:    len(fromStart),
+		DeadEndStates:      []position.Position{},
+		UnreachableGoals:   []position.Position{},
+	}
+	for _, goal := range l.GoalPositions() {
+		distance, ok := fromStart[goal]
+		if !ok {
+			analysis.UnreachableGoals = append(analysis.UnreachableGoals, goal)
+			continue
+		}
+		if analysis.ShortestPathLength < 0 || distance < analysis.ShortestPathLength {
+			analysis.ShortestPathLength = distance
+		}
+	}
+
+	// 行き止まりの一覧を行優先の順序で並べるため，マップを走査する
+	for y := 0; y < l.Height; y++ {
+		for x := 0; x < l.Width; x++ {
+			pos := position.Position{Y: y, X: x}
+			if _, reachable := fromStart[pos]; !reachable {
+				continue
+			}
+			if l.LakeMap[y][x] == "x" {
+				analysis.HoleStates++
+				continue
+			}
+			if !toGoal[pos] {
+				analysis.DeadEndStates = append(analysis.DeadEndStates, pos)
+			}
+		}
+	}
+
+	return analysis
+}
+
+func (a Analysis) String() string {
+	deadEnds := make([]string, len(a.DeadEndStates))
+	for i, pos := range a.DeadEndStates {
+		deadEnds[i] = pos.String()
+	}
+	report := fmt.Sprintf("shortest path: %d steps, reachable states: %d (holes: %d), dead-end states: %d [%s]",
+		a.ShortestPathLength, a.ReachableStates, a.HoleStates, len(a.DeadEndStates), strings.Join(deadEnds, ", "))
+	if len(a.UnreachableGoals) > 0 {
+		report += fmt.Sprintf(", unreachable goals: %v", a.UnreachableGoals)
+	}
+	return report
+}
+
+// いずれかのゴール地点に到達可能な状態の集合を求める
+// 地面同士の移動は可逆なので，全てのゴールから地面だけを辿る幅優先探索で求められる
+func (l FrozenLake) statesReachingGoal() map[position.Position]bool {
+	reaching := map[position.Position]bool{}
+	queue := []position.Position{}
+	for _, goal := range l.GoalPositions() {
+		reaching[goal] = true
+		queue = append(queue, goal)
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, move := range neighborMoves {
+			prev := position.Position{Y: current.Y + move.Y, X: current.X + move.X}
+			if !l.inBounds(prev) || reaching[prev] || l.isTerminal(prev) {
+				continue
+			}
+			reaching[prev] = true
+			queue = append(queue, prev)
+		}
+	}
+
+	return reaching
+}
@@ -6,15 +6,20 @@ import (
 	"encoding/csv"
 	"flag"
 	"fmt"
-	"math"
-	mrand "math/rand" // crypto/randとの名前衝突を避けるためmath/randにエイリアスmrandを適用
 	"os"
 	"pprlgoFrozenLake/agent"
+	"pprlgoFrozenLake/config"
 	"pprlgoFrozenLake/doublenc"
 	"pprlgoFrozenLake/environment"
 	"pprlgoFrozenLake/frozenlake"
 	"pprlgoFrozenLake/party"
+	"pprlgoFrozenLake/position"
+	"pprlgoFrozenLake/pprl"
+	"pprlgoFrozenLake/qtable"
+	"pprlgoFrozenLake/render"
+	"pprlgoFrozenLake/trajectory"
 	"pprlgoFrozenLake/utils"
+	"strings"
 	"time"
 
 	"github.com/tuneinsight/lattigo/v4/bfv"
@@ -26,50 +31,387 @@ const (
 	MAX_USERS  = 2             // MAX_USERS = cloud + agents
 	MAX_AGENTS = MAX_USERS - 1 // agents = MAX_USERS - cloud
 	MAX_TRIALS = 100
+	MAX_STEPS  = 100 // 1エピソードの最大ステップ数のデフォルト値
+
+	ADAPT_WINDOW = 10 // 湖の変化後の再適応の判定に使用する移動平均のエピソード数
+
+	EVAL_EPISODES = 100 // -evaluateで貪欲方策を評価するエピソード数
 )
 
 func main() {
-	// agent.ChooseRandomAction()やagent.EpsilonGreedyAction()で使用する乱数値を固定
-	// 補足：rand.Seedはグローバルな乱数生成器のため、意図せず他のプログラムの乱数生成も固定してしまう可能性がある
-	// 　　　rand.New(rand.NewSource(0))はローカルな乱数生成器のため、プログラムごとに乱数生成を固定でき、他のプログラムに影響を与えない
-	// 　　　しかし、今回はプログラム全体で乱数を固定したいので、rand.Seedを使用する
-	mrand.Seed(0)
-
 	// コマンドライン引数でマップのサイズを指定
 	map_size := flag.String("s", "", "Size of the Frozen Lake map (options: 4x4, 5x5, 6x6)")
+	map_path := flag.String("map", "", "Frozen Lake map file in Gymnasium's SFHG format, or an inline map with rows separated by commas (e.g. SFFF,FHFH,FFFH,HFFG)")
+	gen_size := flag.String("gen", "", "Generate a random solvable lake of the given size (e.g. 8x8, 12x12) and train on it")
+	hole_density := flag.Float64("holes", 0.2, "Probability of each cell being a hole when generating a lake with -gen")
+	gen_seed := flag.Int64("gen-seed", 0, "Seed for generating a lake with -gen")
+	show_render := flag.Bool("render", false, "Print the lake with the learned greedy policy and state values after training")
+	svg_path := flag.String("svg", "", "Export a state-value heatmap with the greedy policy to this SVG file after training")
+	svg_table := flag.String("svg-table", "cloud", "Q-table drawn in the SVG heatmap (options: cloud, agent)")
+	config_path := flag.String("config", "", "Experiment config file (JSON) specifying e.g. the reward scheme")
+	max_steps := flag.Int("max-steps", MAX_STEPS, "Maximum number of steps per episode before it is truncated")
+	slip_text := flag.String("slip", environment.SLIP_NONE, "Slip model: none, gym (Gymnasium's is_slippery), <intended> (the rest slips perpendicular, e.g. 0.8) or <intended>,<opposite> (e.g. 0.8,0.1)")
+	record_path := flag.String("record", "", "Record every training step (state, action, reward, next state, done, epsilon draw) to this JSONL file")
+	replay_path := flag.String("replay", "", "Replay a trajectory recorded with -record, verify that it is deterministic and exit (no training)")
+	action_set := flag.String("actions", "4", "Action set of the agent (options: 4, 8, 4+stay, 8+stay); the encrypted Q-table uses one slot per action")
+	vec_num := flag.Int("vec", 1, "Number of copies of the lake each agent runs in lockstep; the queries of all copies are packed into one secure request per step (cannot be combined with a config schedule)")
+	replay_render := flag.Bool("replay-render", false, "Render the lake after each replayed step (used with -replay)")
+	windy := flag.Bool("windy", false, "Enable the column/row wind declared in the map file (wind col ... / wind row ...), which pushes the agent extra cells after each move")
+	learner_name := flag.String("learner", "q", "Learning algorithm whose Qnew is pushed to the encrypted Q-table (options: q, sarsa, expected-sarsa, double-q, q-lambda, sarsa-lambda, mc-first, mc-every, dyna-q)")
+	lambda := flag.Float64("lambda", agent.DEFAULT_LAMBDA, "Trace decay λ for -learner q-lambda / sarsa-lambda")
+	trace_kind := flag.String("trace", "replacing", "Eligibility trace for -learner q-lambda / sarsa-lambda (options: replacing, accumulating)")
+	planning_steps := flag.Int("planning", agent.DEFAULT_PLANNING_STEPS, "Number of planning updates on the agent's private model per real step for -learner dyna-q")
+	planning_push := flag.String("planning-push", agent.PLANNING_PUSH_BATCH, "How -learner dyna-q pushes planned Q values to the encrypted Q-table (options: step = one request per real/planning update, batch = one aggregated request per real step)")
+	explore_name := flag.String("explore", "epsilon", "Exploration policy; values are fetched from the encrypted Q-table only when the policy needs them (options: epsilon, softmax, ucb)")
+	epsilon := flag.Float64("epsilon", agent.EPSILON, "Exploration rate ε of -explore epsilon (initial value when decayed)")
+	epsilon_end := flag.Float64("epsilon-end", agent.DEFAULT_EPSILON_END, "Final ε of -explore epsilon with -decay linear / exponential")
+	temperature := flag.Float64("temperature", agent.DEFAULT_TEMPERATURE, "Temperature of -explore softmax (initial value when decayed)")
+	temperature_end := flag.Float64("temperature-end", agent.DEFAULT_TEMPERATURE_END, "Final temperature of -explore softmax with -decay linear / exponential")
+	decay_kind := flag.String("decay", agent.DECAY_NONE, "Per-episode decay of ε / temperature (options: none, linear, exponential)")
+	decay_episodes := flag.Int("decay-episodes", EPISODES, "Episodes until ε / temperature reaches its final value (-decay linear) or time constant (-decay exponential)")
+	ucb_c := flag.Float64("ucb-c", agent.DEFAULT_UCB_C, "Exploration weight C of -explore ucb: Q(s,a) + C * sqrt(ln N(s) / N(s,a))")
+	master_seed := flag.Int64("seed", 0, "Master seed; every trial, agent and environment gets its own random source derived from it, so each trial is reproducible on its own")
+	trial_only := flag.Int("trial", -1, "Run only this trial (0-based) with the same random sources it gets in a full run (-1: run all trials)")
+	obs_kind := flag.String("obs", environment.OBS_FULL, "Observation of the agent (options: full, window, noisy); with window/noisy the Q-table is indexed by observation IDs, so aliased states share an encrypted row")
+	obs_radius := flag.Int("obs-radius", 1, "Radius of the local window of cell types observed with -obs window (1: 3x3 neighborhood)")
+	obs_noise := flag.Float64("obs-noise", 0.1, "Probability of observing a random neighboring cell instead of the true position with -obs noisy")
+	save_qtable := flag.String("save-qtable", "", "Save the agent's plaintext Q-table after training to this file (.csv: CSV, otherwise JSON; both include the lake, actions and observation)")
+	save_cloud := flag.String("save-cloud", "", "Save the encrypted cloud Q-table after training to this file (ciphertexts and the BFV parameters)")
+	save_key := flag.Bool("save-key", false, "Include the BFV secret key in -save-cloud so the table can be decrypted and trained further later")
+	load_qtable := flag.String("load-qtable", "", "Start every trial from a Q-table saved with -save-qtable (the cloud table is encrypted from it unless -load-cloud is given)")
+	load_cloud := flag.String("load-cloud", "", "Start every trial from an encrypted Q-table saved with -save-cloud -save-key (its BFV parameters and secret key are reused)")
+	evaluate := flag.Bool("evaluate", false, "Evaluate the greedy policy of the loaded Q-table (-load-qtable or -load-cloud) without training and exit")
 	flag.Parse()
 
-	// `s`オプションが指定されているかチェック。指定されていなければ終了
-	if *map_size == "" {
-		fmt.Println("Error: The -s option is required.")
+	// 記録した軌跡の再生のみを行う場合は学習しない
+	if *replay_path != "" {
+		replayTrajectory(*replay_path, *replay_render)
+		return
+	}
+
+	cfg := config.Default()
+	if *config_path != "" {
+		var err error
+		cfg, err = config.Load(*config_path)
+		if err != nil {
+			fmt.Println("Error: invalid -config option:", err)
+			os.Exit(1)
+		}
+	}
+	learningRate, err := cfg.LearningRateConfig()
+	if err != nil {
+		fmt.Println("Error: invalid learning rate:", err)
+		os.Exit(1)
+	}
+	rewardCfg, err := cfg.RewardConfig()
+	if err != nil {
+		fmt.Println("Error: invalid reward config:", err)
+		os.Exit(1)
+	}
+
+	if *svg_table != "cloud" && *svg_table != "agent" {
+		fmt.Println("Error: invalid -svg-table option. Please choose from cloud or agent.")
+		os.Exit(1)
+	}
+
+	// 学習器はエピソードをまたぐ状態(適格度など)を持つことがあるので，エージェントごとに作成する
+	newLearner := func() (agent.Learner, error) {
+		learner, err := agent.LearnerByName(*learner_name)
+		if traces, ok := learner.(*agent.EligibilityTraces); ok {
+			traces.Lambda = *lambda
+			traces.Accumulate = *trace_kind == "accumulating"
+		}
+		if dyna, ok := learner.(*agent.DynaQ); ok {
+			dyna.Planning = *planning_steps
+			dyna.Push = *planning_push
+		}
+		return learner, err
+	}
+	// 探索方策も訪問回数などを持つので，エージェントごとに作成する
+	newExploration := func() (agent.ExplorationPolicy, error) {
+		policy, err := agent.ExplorationByName(*explore_name)
+		switch p := policy.(type) {
+		case *agent.EpsilonGreedy:
+			p.Decay = agent.DecaySchedule{Kind: *decay_kind, Start: *epsilon, End: *epsilon_end, Episodes: *decay_episodes}
+		case *agent.Softmax:
+			p.Temperature = agent.DecaySchedule{Kind: *decay_kind, Start: *temperature, End: *temperature_end, Episodes: *decay_episodes}
+		case *agent.UCB:
+			p.C = *ucb_c
+		}
+		return policy, err
+	}
+	if _, err := newExploration(); err != nil {
+		fmt.Println("Error: invalid -explore option:", err)
+		os.Exit(1)
+	}
+	if err := (agent.DecaySchedule{Kind: *decay_kind, Episodes: *decay_episodes}).Validate(); err != nil {
+		fmt.Println("Error: invalid -decay option:", err)
+		os.Exit(1)
+	}
+	if *epsilon < 0 || *epsilon > 1 || *epsilon_end < 0 || *epsilon_end > 1 {
+		fmt.Println("Error: the -epsilon and -epsilon-end options must be in [0, 1].")
+		os.Exit(1)
+	}
+	if *temperature <= 0 || *temperature_end <= 0 {
+		fmt.Println("Error: the -temperature and -temperature-end options must be positive.")
+		os.Exit(1)
+	}
+	if *ucb_c < 0 {
+		fmt.Println("Error: the -ucb-c option must be non-negative.")
+		os.Exit(1)
+	}
+
+	learner, err := newLearner()
+	if err != nil {
+		fmt.Println("Error: invalid -learner option:", err)
+		os.Exit(1)
+	}
+	// Expected SARSAの目標値は探索方策の行動の選択確率による期待値なので，選択確率を求められる探索方策が必要
+	if policy, _ := newExploration(); learner.Name() == "expected-sarsa" {
+		if _, ok := policy.(agent.StochasticPolicy); !ok {
+			fmt.Printf("Error: the -learner expected-sarsa option cannot be used with -explore %s.\n", *explore_name)
+			os.Exit(1)
+		}
+	}
+	if *lambda < 0 || *lambda > 1 {
+		fmt.Println("Error: the -lambda option must be in [0, 1].")
+		os.Exit(1)
+	}
+	if *trace_kind != "replacing" && *trace_kind != "accumulating" {
+		fmt.Println("Error: invalid -trace option. Please choose from replacing or accumulating.")
+		os.Exit(1)
+	}
+	if *planning_steps < 0 {
+		fmt.Println("Error: the -planning option must be non-negative.")
+		os.Exit(1)
+	}
+	if *planning_push != agent.PLANNING_PUSH_STEP && *planning_push != agent.PLANNING_PUSH_BATCH {
+		fmt.Println("Error: invalid -planning-push option. Please choose from step or batch.")
+		os.Exit(1)
+	}
+
+	actions, err := environment.ActionSetByName(*action_set)
+	if err != nil {
+		fmt.Println("Error: invalid -actions option:", err)
+		os.Exit(1)
+	}
+
+	if *vec_num <= 0 {
+		fmt.Println("Error: the -vec option must be positive.")
+		os.Exit(1)
+	}
+	if _, ok := learner.(agent.BatchLearner); ok && *vec_num > 1 {
+		fmt.Printf("Error: the -learner %s option cannot be used with -vec.\n", *learner_name)
+		os.Exit(1)
+	}
+	if *vec_num > 1 && *record_path != "" {
+		fmt.Println("Error: the -record option cannot be used with -vec.")
+		os.Exit(1)
+	}
+	// 湖の変化は学習ループのエピソード番号で記録・検査するが，自動リセットする各コピーは独自にエピソードを数える
+	if *vec_num > 1 && len(cfg.Schedule) > 0 {
+		fmt.Println("Error: a config with a schedule cannot be used with -vec.")
+		os.Exit(1)
+	}
+
+	observation := environment.ObservationModel{Kind: *obs_kind, Radius: *obs_radius, Noise: *obs_noise}
+	if err := observation.Validate(); err != nil {
+		fmt.Println("Error: invalid -obs option:", err)
+		os.Exit(1)
+	}
+	// 記録した軌跡の再生は実際の状態IDで検証するため，部分観測では記録できない
+	if observation.Kind != environment.OBS_FULL && *record_path != "" {
+		fmt.Println("Error: the -record option cannot be used with -obs window or -obs noisy.")
+		os.Exit(1)
+	}
+
+	if *trial_only < -1 || *trial_only >= MAX_TRIALS {
+		fmt.Printf("Error: the -trial option must be between 0 and %d (or -1 for all trials).\n", MAX_TRIALS-1)
+		os.Exit(1)
+	}
+	// 実行する試行の範囲 [first_trial, last_trial)
+	first_trial, last_trial := 0, MAX_TRIALS
+	if *trial_only >= 0 {
+		first_trial, last_trial = *trial_only, *trial_only+1
+	}
+	num_trials := last_trial - first_trial
+
+	if *max_steps <= 0 {
+		fmt.Println("Error: the -max-steps option must be positive.")
+		os.Exit(1)
+	}
+
+	slip, err := environment.ParseSlipModel(*slip_text)
+	if err != nil {
+		fmt.Println("Error: invalid -slip option:", err)
+		os.Exit(1)
+	}
+
+	// `s`・`map`・`gen`オプションのいずれかが指定されているかチェック。指定されていなければ終了
+	if *map_size == "" && *map_path == "" && *gen_size == "" {
+		fmt.Println("Error: One of the -s, -map or -gen options is required.")
 		os.Exit(1)
 	}
 
 	var lake frozenlake.FrozenLake
-	switch *map_size {
-	case "3x3":
-		lake = frozenlake.FrozenLake3x3
-	case "4x4":
-		lake = frozenlake.FrozenLake4x4
-	case "5x5":
-		lake = frozenlake.FrozenLake5x5
-	case "6x6":
-		lake = frozenlake.FrozenLake6x6
+	switch {
+	case *map_path != "":
+		var err error
+		lake, err = loadLake(*map_path)
+		if err != nil {
+			fmt.Println("Error: invalid -map option:", err)
+			os.Exit(1)
+		}
+	case *gen_size != "":
+		var width, height int
+		if _, err := fmt.Sscanf(*gen_size, "%dx%d", &width, &height); err != nil {
+			fmt.Println("Error: invalid -gen option (expected WIDTHxHEIGHT, e.g. 8x8):", err)
+			os.Exit(1)
+		}
+		var err error
+		lake, err = frozenlake.GenerateLake(width, height, *hole_density, *gen_seed)
+		if err != nil {
+			fmt.Println("Error: failed to generate a lake:", err)
+			os.Exit(1)
+		}
+		// 生成したマップは-mapオプションでそのまま再利用できる形式で表示する
+		fmt.Printf("Generated lake (%dx%d, hole density %.2f, seed %d):\n%s", width, height, *hole_density, *gen_seed, lake)
 	default:
-		fmt.Println("Invalid map size. Please choose from 4x4, 5x5, or 6x6.")
+		switch *map_size {
+		case "3x3":
+			lake = frozenlake.FrozenLake3x3
+		case "4x4":
+			lake = frozenlake.FrozenLake4x4
+		case "5x5":
+			lake = frozenlake.FrozenLake5x5
+		case "6x6":
+			lake = frozenlake.FrozenLake6x6
+		default:
+			fmt.Println("Invalid map size. Please choose from 4x4, 5x5, or 6x6.")
+			os.Exit(1)
+		}
+	}
+
+	// 学習を始める前にマップの整合性を検査し，解析結果を表示する
+	if err := lake.Validate(); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if err := rewardCfg.ValidateLake(lake, agent.GAMMA, agent.SummedQtables(learner)); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Lake analysis:", lake.Analyze())
+	if *windy && len(lake.ColumnWind) == 0 && len(lake.RowWind) == 0 {
+		fmt.Println("Error: the -windy option requires a map file with wind directives (wind col ... / wind row ...).")
 		os.Exit(1)
 	}
 
-	environments := make([]*environment.Environment, MAX_AGENTS)
+	// 湖の変化の予定は学習前に全エピソード分を適用してみて，変化後の湖が整合しているかを検査する
+	if err := cfg.Schedule.Validate(lake, EPISODES); err != nil {
+		fmt.Println("Error: invalid schedule in the config:", err)
+		os.Exit(1)
+	}
+
+	// 予定が空の場合は湖は変化しない
+	newEnvironment := func() *environment.NonStationaryEnv {
+		env := environment.NewEnvironment(lake, rewardCfg)
+		env.Slip = slip
+		env.MaxSteps = *max_steps
+		env.Windy = *windy
+		env.Actions = actions
+		return environment.NewNonStationaryEnv(env, cfg.Schedule)
+	}
+
+	// エージェントが観測する環境 (部分観測の場合は観測IDを返す環境で包む)
+	observe := func(env *environment.NonStationaryEnv) seededEnv {
+		if observation.Kind == environment.OBS_FULL {
+			return env
+		}
+		return environment.NewPartialObsEnv(env, observation)
+	}
+
+	environments := make([]*environment.NonStationaryEnv, MAX_AGENTS)
+	observed_envs := make([]seededEnv, MAX_AGENTS)
 	agents := make([]*agent.Agent, MAX_AGENTS)
 
 	for i := 0; i < MAX_AGENTS; i++ {
-		environments[i] = environment.NewEnvironment(lake)
-		agents[i] = agent.NewAgent(environments[i])
+		environments[i] = newEnvironment()
+		observed_envs[i] = observe(environments[i])
+		agents[i] = agent.NewAgent(observed_envs[i])
+		agents[i].Learner, _ = newLearner()
+		agents[i].Exploration, _ = newExploration()
+		agents[i].Epsilon = *epsilon
+		agents[i].LearningRate = learningRate
+	}
+	if partial, ok := observed_envs[0].(*environment.PartialObsEnv); ok {
+		fmt.Printf("Partial observation (%s): %d observation IDs for %d states, %d states are aliased\n",
+			observation.Kind, partial.ObservationSpace(), environments[0].ObservationSpace(), partial.AliasedStates())
+	}
+
+	// -vecオプションが指定された場合は，各エージェントが湖のコピーを複数同時に進める
+	var vec_envs []*environment.VecEnv
+	vec_bases := make([][]*environment.NonStationaryEnv, MAX_AGENTS) // 各コピーの(観測で包む前の)環境
+	vec_states := make([][]int, MAX_AGENTS)                          // 各コピーの現在の状態 (エピソードをまたいで引き継ぐ)
+	vec_actions := make([][]int, MAX_AGENTS)                         // 各コピーで選択済みの次の行動 (方策オン型の学習のみ．未選択は-1)
+	if *vec_num > 1 {
+		vec_envs = make([]*environment.VecEnv, MAX_AGENTS)
+		for i := 0; i < MAX_AGENTS; i++ {
+			vec_bases[i] = make([]*environment.NonStationaryEnv, *vec_num)
+			copies := make([]environment.Env, *vec_num)
+			for j := range copies {
+				vec_bases[i][j] = newEnvironment()
+				copies[j] = observe(vec_bases[i][j])
+			}
+			vec_envs[i] = environment.NewVecEnv(copies)
+		}
 	}
 	Agt := agents[0]
 	Env := environments[0]
 
+	// 保存したQテーブルの読み込み (読み込んだ場合は各試行をそのQ値から始める)
+	if *evaluate && *load_qtable == "" && *load_cloud == "" {
+		fmt.Println("Error: the -evaluate option requires -load-qtable or -load-cloud.")
+		os.Exit(1)
+	}
+	metadata := qtable.NewMetadata(Env.Spec(), observation, Agt.Learner.Name(), *action_set, Agt.SummedQtables())
+	var loaded_qtable *qtable.Plaintext
+	if *load_qtable != "" {
+		loaded, err := loadPlaintextQtable(*load_qtable, metadata, Agt)
+		if err != nil {
+			fmt.Println("Error: invalid -load-qtable option:", err)
+			os.Exit(1)
+		}
+		loaded_qtable = &loaded
+	}
+	var loaded_cloud *loadedCloudQtable
+	if *load_cloud != "" {
+		loaded, err := loadCloudQtable(*load_cloud, metadata, Agt)
+		if err != nil {
+			fmt.Println("Error: invalid -load-cloud option:", err)
+			os.Exit(1)
+		}
+		loaded_cloud = &loaded
+	}
+
+	// 各試行の開始時にエージェントのQテーブルを読み込んだQ値にする (平文のQテーブルを優先し，なければ復号したクラウドのQテーブル)
+	loadAgentQtables := func(agt *agent.Agent) {
+		if loaded_qtable != nil {
+			agt.SetQtables(loaded_qtable.Qtable, loaded_qtable.QtableB)
+		} else if loaded_cloud != nil {
+			agt.SetQtablesFromCloud(loaded_cloud.decrypted)
+		}
+	}
+
+	if *evaluate {
+		Agt.QtableReset()
+		loadAgentQtables(Agt)
+		Agt.Seed(utils.DeriveSeed(*master_seed, utils.SEED_AGENT))
+		observed_envs[0].Seed(utils.DeriveSeed(*master_seed, utils.SEED_ENV))
+		evaluateLoadedQtable(observed_envs[0], Env, Agt, *show_render)
+		return
+	}
+
 	// --- set up for Result
 	success_rate_filename := fmt.Sprintf("PPRL_success_rate_%dx%d.csv", Env.Height(), Env.Width())
 	file, err := os.Create(success_rate_filename)
@@ -87,19 +429,36 @@ func main() {
 	}
 
 	// --- set up for bfv
-	params, err := bfv.NewParametersFromLiteral(utils.FAST_BUT_NOT_128_SECURITY) // bfv.PN15QP880 // utils.FAST_BUT_NOT_128_SECURITY
+	// 暗号化されたQテーブルを読み込んだ場合は，保存したパラメータと秘密鍵を使って続きを学習する
+	params_literal := utils.FAST_BUT_NOT_128_SECURITY
+	if loaded_cloud != nil {
+		params_literal = loaded_cloud.params.ParametersLiteral()
+	}
+	params, err := bfv.NewParametersFromLiteral(params_literal) // bfv.PN15QP880 // utils.FAST_BUT_NOT_128_SECURITY
 	if err != nil {
 		panic(err)
 	}
 
+	// 暗号化されたQテーブルは1つの暗号文の各スロットに各行動のQ値を格納するため，行動数はスロット数以下でなければならない
+	if Agt.GetActionNum() > params.N() {
+		fmt.Printf("Error: %d actions do not fit in the %d slots of a BFV ciphertext.\n", Agt.GetActionNum(), params.N())
+		os.Exit(1)
+	}
+
 	// キージェネレータ、エンコーダ、暗号化器、評価器、復号器の生成
 	kgen := bfv.NewKeyGenerator(params)
 	sk, pk := kgen.GenKeyPair()
+	if loaded_cloud != nil {
+		sk = loaded_cloud.sk
+		pk = kgen.GenPublicKey(sk)
+	}
 	encoder := bfv.NewEncoder(params)
 	encryptor := bfv.NewEncryptor(params, pk)
 	decryptor := bfv.NewDecryptor(params, sk)
 	rlk := kgen.GenRelinearizationKey(sk, 1)
-	evaluator := bfv.NewEvaluator(params, rlwe.EvaluationKey{Rlk: rlk})
+	// ベクトル化環境の行動選択では，クラウドがQテーブルの行を回転して複数の問い合わせを1つの暗号文に詰める
+	rtks := kgen.GenRotationKeysForRotations(pprl.SelectionRotations(params, Agt.GetActionNum()), true, sk)
+	evaluator := bfv.NewEvaluator(params, rlwe.EvaluationKey{Rlk: rlk, Rtks: rtks})
 	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
 	publicKey := &privateKey.PublicKey
 
@@ -114,23 +473,71 @@ func main() {
 	}
 
 	// ---PPRL ---
+	var recorder *trajectory.Recorder
+	if *record_path != "" {
+		recorder, err = trajectory.NewRecorder(*record_path)
+		if err != nil {
+			panic(err)
+		}
+		defer recorder.Close()
+	}
+
+	var encryptedQtable []*rlwe.Ciphertext // クラウド上のQテーブル (学習後の描画のため，最後の試行のものをループの外に残す)
 	var success_rate_per_episode = make([][]float64, MAX_TRIALS)
+	episode_success_rates := make([]float64, EPISODES+1) // 各エピソード単独の成功率 (全試行の平均)．湖の変化後の再適応の速さの計測に使用する
+	goal_counts := map[position.Position]int{}           // ゴール地点ごとの到達回数 (全試行の合計)
 	var totalDuration time.Duration
-	for trial := 0; trial < MAX_TRIALS; trial++ {
+	for trial := first_trial; trial < last_trial; trial++ {
 		goal_count := 0.0
 		all_agt_eps := 0 // 各エージェントの試行回数の総計
 
+		// 試行のシードはマスターシードと試行番号のみから決まるので，-trialで1つの試行だけを実行しても同じ結果になる
+		trial_seed := utils.DeriveSeed(*master_seed, int64(trial))
 		for agent_idx := 0; agent_idx < MAX_AGENTS; agent_idx++ {
-			agents[agent_idx].QtableReset(environments[agent_idx])
+			agents[agent_idx].QtableReset()
+			loadAgentQtables(agents[agent_idx])
+
+			// エージェント・環境ごとに独立した乱数を設定し，行動選択と滑りを含めた遷移を試行単位で再現可能にする
+			// 湖の変化も試行ごとに最初からやり直す
+			agents[agent_idx].Seed(utils.DeriveSeed(trial_seed, utils.SEED_AGENT, int64(agent_idx)))
+			observed_envs[agent_idx].Seed(utils.DeriveSeed(trial_seed, utils.SEED_ENV, int64(agent_idx)))
+			environments[agent_idx].RestartSchedule()
+			if vec_envs != nil {
+				vec_envs[agent_idx].Seed(utils.DeriveSeed(trial_seed, utils.SEED_VEC_ENV, int64(agent_idx)))
+				for _, base := range vec_bases[agent_idx] {
+					base.RestartSchedule()
+				}
+				vec_states[agent_idx] = vec_envs[agent_idx].Reset()
+				vec_actions[agent_idx] = make([]int, *vec_num)
+				for i := range vec_actions[agent_idx] {
+					vec_actions[agent_idx][i] = -1
+				}
+			}
+			if recorder != nil {
+				if err := recorder.BeginEnv(trial, agent_idx, environments[agent_idx].Spec()); err != nil {
+					panic(err)
+				}
+			}
 		}
 
 		// 試行ごとにクラウドのQ値を初期化
 		// 各エージェントの状態数・行動数は同一のため、いずれのagentsを用いて初期化しても問題ない。今回は代表としてagents[0]を使用する
-		encryptedQtable := make([]*rlwe.Ciphertext, Agt.GetStateNum())
-		for i := 0; i < Agt.GetStateNum(); i++ {
+		// Double Q学習ではQ_AとQ_Bの2つのQテーブルを連結して保持する
+		// 暗号化されたQテーブルを読み込んだ場合はその暗号文の複製から，平文のQテーブルを読み込んだ場合はそれを暗号化して始める
+		encryptedQtable = make([]*rlwe.Ciphertext, Agt.EncryptedQtableRows())
+		initial_rows := Agt.CloudLayoutQtable() // 直前にloadAgentQtables()で読み込んだQ値
+		for i := 0; i < Agt.EncryptedQtableRows(); i++ {
+			if loaded_cloud != nil {
+				encryptedQtable[i] = loaded_cloud.table[i].CopyNew()
+				continue
+			}
+
 			plaintext := make([]uint64, Agt.GetActionNum())
-			for i := range plaintext {
-				plaintext[i] = 0 // Agt.InitValQ
+			for j := range plaintext {
+				plaintext[j] = 0 // Agt.InitValQ
+				if loaded_qtable != nil {
+					plaintext[j], _ = utils.EncodeQ(initial_rows[i][j], Agt.SummedQtables()) // 範囲はloadPlaintextQtable()で検査済み
+				}
 			}
 
 			ciphertext := doublenc.BFVenc(params, encoder, encryptor, plaintext)
@@ -140,36 +547,73 @@ func main() {
 		for episode := 0; episode <= EPISODES; episode++ {
 			startTime := time.Now() // 処理開始時刻
 
-			average_time := totalDuration / (time.Duration(episode) + time.Duration(trial)*EPISODES + 1) // ゼロ除算を避けるため +1 する
-			remained_train_cnt := EPISODES*MAX_TRIALS - (episode + (trial * EPISODES))
+			average_time := totalDuration / (time.Duration(episode) + time.Duration(trial-first_trial)*EPISODES + 1) // ゼロ除算を避けるため +1 する
+			remained_train_cnt := EPISODES*num_trials - (episode + ((trial - first_trial) * EPISODES))
 			prediction_time := average_time * time.Duration(remained_train_cnt)
 
 			// 学習の進捗率を表示
 			progress := float64(episode) / float64(EPISODES) * 100
 			fmt.Printf("\rTraining Progress (Trial - %d/%d): %.1f%% (%d/%d), 終了予定時間: %s", trial, MAX_TRIALS, progress, episode, EPISODES, prediction_time)
 
+			episode_goal_count := goal_count
+			episode_start_eps := all_agt_eps
 			for agent_idx := 0; agent_idx < MAX_AGENTS; agent_idx++ {
-				env := environments[agent_idx]
+				env := observed_envs[agent_idx]
 				agt := agents[agent_idx]
 
-				state := env.Reset()
-				for {
-					action := agt.SecureEpsilonGreedyAction(state, bfvKeyTools, encryptedQtable)
+				if vec_envs != nil {
+					goals, episodes := runVectorizedEpisodes(vec_envs[agent_idx], vec_states[agent_idx], vec_actions[agent_idx], agt, bfvKeyTools, encryptedQtable, goal_counts)
+					goal_count += goals
+					all_agt_eps += episodes
+				} else {
+					state := env.Reset()
+					action := agt.SecureSelectAction(state, bfvKeyTools, encryptedQtable)
+					epsilon_draw := agt.LastEpsilonDraw()
+					for t := 0; ; t++ {
+						next_state, reward, terminated, truncated, info := env.Step(action)
 
-					next_state, reward, done := env.Step(action)
-					agt.Learn(state, action, reward, next_state, bfvKeyTools, encryptedQtable)
+						// SARSAなどの方策オン型の学習では，次の状態の行動を先に選択して目標値に使用する
+						// 打ち切られた場合も次の状態の価値でブートストラップするため，行動を選択する
+						next_action := -1
+						next_epsilon_draw := 0.0
+						if agt.Learner.OnPolicy() && !terminated {
+							next_action = agt.SecureSelectAction(next_state, bfvKeyTools, encryptedQtable)
+							next_epsilon_draw = agt.LastEpsilonDraw()
+						}
+						agt.Learn(agent.Transition{State: state, Action: action, Reward: reward, NextState: next_state, NextAction: next_action, Terminated: terminated, Truncated: truncated}, bfvKeyTools, encryptedQtable)
 
-					if done {
-						if next_state == env.GoalPos {
-							goal_count++
+						if recorder != nil {
+							err := recorder.Record(trajectory.Step{
+								Trial: trial, Agent: agent_idx, Episode: episode, T: t,
+								State: state, Action: action, Reward: reward, NextState: next_state,
+								Terminated: terminated, Truncated: truncated, EpsilonDraw: epsilon_draw,
+							})
+							if err != nil {
+								panic(err)
+							}
 						}
-						all_agt_eps++
 
-						break
+						if terminated || truncated {
+							if info[environment.INFO_GOAL] == true {
+								goal_count++
+								goal_counts[info[environment.INFO_GOAL_POSITION].(position.Position)]++
+							}
+							all_agt_eps++
+
+							break
+						}
+						state = next_state
+						if next_action >= 0 {
+							action, epsilon_draw = next_action, next_epsilon_draw
+						} else {
+							action = agt.SecureSelectAction(state, bfvKeyTools, encryptedQtable)
+							epsilon_draw = agt.LastEpsilonDraw()
+						}
 					}
-					state = next_state
 				}
 
+				agt.EndEpisode()
+
 				// 成功率を算出してcsvに出力
 				goal_rate := goal_count / float64(all_agt_eps)
 				writer.Write([]string{fmt.Sprintf("%d", int(episode)), fmt.Sprintf("%.2f", goal_rate)})
@@ -181,6 +625,7 @@ func main() {
 				*/
 				success_rate_per_episode[trial] = append(success_rate_per_episode[trial], goal_rate)
 			}
+			episode_success_rates[episode] += (goal_count - episode_goal_count) / float64(all_agt_eps-episode_start_eps) / float64(num_trials)
 
 			endTime := time.Now()              // 処理終了時刻
 			duration := endTime.Sub(startTime) // 経過時間を計算
@@ -193,7 +638,7 @@ func main() {
 	fmt.Println(len(success_rate_per_episode))
 	for _, goal_rates := range success_rate_per_episode {
 		for episode, goal_rate := range goal_rates {
-			average_success_rates[episode] += goal_rate / float64(MAX_TRIALS)
+			average_success_rates[episode] += goal_rate / float64(num_trials)
 		}
 	}
 
@@ -209,20 +654,363 @@ func main() {
 	defer average_writer.Flush()
 
 	// ヘッダーを書き込む
-	average_writer.Write([]string{"Episode", "Average Success Rate"})
+	average_writer.Write([]string{"Episode", "Average Success Rate", "Episode Success Rate", "Map Changes"})
 
-	// データを書き込む
+	// データを書き込む (湖が変化したエピソードには変化の内容を記入する)
 	for episode, average_success_rate := range average_success_rates {
-		average_writer.Write([]string{fmt.Sprintf("%d", episode), fmt.Sprintf("%.2f", average_success_rate)})
+		changes := []string{}
+		for _, change := range cfg.Schedule.ChangesAt(episode) {
+			changes = append(changes, change.String())
+		}
+		average_writer.Write([]string{fmt.Sprintf("%d", episode), fmt.Sprintf("%.2f", average_success_rate), fmt.Sprintf("%.2f", episode_success_rates[episode]), strings.Join(changes, "; ")})
+	}
+
+	if len(cfg.Schedule) > 0 {
+		writeMapChanges(fmt.Sprintf("PPRL_map_changes_%dx%d.csv", Env.Height(), Env.Width()), cfg.Schedule, episode_success_rates)
+	}
+
+	// ゴール地点ごとの到達回数をCSVに書き出す (ゴールが複数ある湖でどのゴールを目指したかを確認する)
+	goal_counts_filename := fmt.Sprintf("PPRL_goal_counts_%dx%d.csv", Env.Height(), Env.Width())
+	goal_counts_file, err := os.Create(goal_counts_filename)
+	if err != nil {
+		panic(err)
+	}
+	defer goal_counts_file.Close()
+
+	goal_counts_writer := csv.NewWriter(goal_counts_file)
+	defer goal_counts_writer.Flush()
+	goal_counts_writer.Write([]string{"Goal X", "Goal Y", "Reward", "Count"})
+	for _, goal := range lake.GoalPositions() {
+		goal_counts_writer.Write([]string{fmt.Sprintf("%d", goal.X), fmt.Sprintf("%d", goal.Y), fmt.Sprintf("%d", Env.Reward(goal, false)), fmt.Sprintf("%d", goal_counts[goal])})
 	}
 
 	fmt.Println()
 
+	// クラウドとの通信パターン (学習アルゴリズムによって1ステップ毎・エピソード毎などに変わる)
+	for agent_idx, agt := range agents {
+		fmt.Printf("Agent %d (%s): %d secure Q-table update requests, %d updated entries\n", agent_idx, agt.Learner.Name(), agt.UpdateRequests, agt.UpdatedEntries)
+		if agt.SelectionRequests > 0 {
+			fmt.Printf("Agent %d: %d packed secure action selection requests, %d packed ciphertexts\n", agent_idx, agt.SelectionRequests, agt.SelectionCiphertexts)
+		}
+		if agt.ClampedEntries > 0 {
+			fmt.Printf("Warning: agent %d clamped %d Q values to the encodable range [%.0f, %.0f]; the cloud Q-table differs from the agent's\n",
+				agent_idx, agt.ClampedEntries, -utils.MaxEncodableValue()/float64(agt.SummedQtables()), utils.MaxEncodableValue()/float64(agt.SummedQtables()))
+		}
+	}
+
 	// その他デバッグ情報の表示
-	agents[0].ShowQTable()
+	agents[0].ShowQTable(observed_envs[0])
 	// agents[0].ShowOptimalPath(environments[0])
-	// ShowDecryptedQTable(agents[0], encryptedQtable, bfvKeyTools.Params, bfvKeyTools.Encoder, bfvKeyTools.Decryptor)
+	// ShowDecryptedQTable(environments[0], agents[0], encryptedQtable, bfvKeyTools.Params, bfvKeyTools.Encoder, bfvKeyTools.Decryptor)
 	// fmt.Println(calcMSE(agents[0], encryptedQtable, bfvKeyTools.Params, bfvKeyTools.Encoder, bfvKeyTools.Decryptor))
+
+	// 学習結果のQテーブルの保存 (最後の試行のもの)
+	if *save_qtable != "" {
+		saved := qtable.Plaintext{Metadata: metadata, Qtable: Agt.Qtable, QtableB: Agt.QtableB}
+		if err := qtable.SavePlaintext(*save_qtable, saved); err != nil {
+			panic(err)
+		}
+		fmt.Println("Agent Q-table written to", *save_qtable)
+	}
+	if *save_cloud != "" {
+		var saved_sk *rlwe.SecretKey
+		if *save_key {
+			saved_sk = sk
+		}
+		saved, err := qtable.NewEncrypted(metadata, params, Agt.GetActionNum(), encryptedQtable, saved_sk)
+		if err != nil {
+			panic(err)
+		}
+		if err := qtable.SaveEncrypted(*save_cloud, saved); err != nil {
+			panic(err)
+		}
+		fmt.Println("Encrypted cloud Q-table written to", *save_cloud)
+	}
+
+	// 学習結果の描画 (エージェントの平文のQテーブルとクラウドのQテーブルを復号したもの)
+	// 部分観測の場合は観測IDで引くQテーブルを湖の各マスに展開して描画する
+	// Double Q学習の場合は2つのQテーブルの和を描画する
+	decryptedQtable := Agt.CloudPolicyQtable(pprl.DecryptQtableWithBFV(params, encoder, decryptor, Agt.GetActionNum(), encryptedQtable))
+	agentQtable := Agt.PolicyQtable()
+	if partial, ok := observed_envs[0].(*environment.PartialObsEnv); ok {
+		decryptedQtable = partial.StateTable(decryptedQtable)
+		agentQtable = partial.StateTable(agentQtable)
+	}
+	if *show_render {
+		fmt.Printf("Agent Qtable (policy / state value):\n%s", render.ASCII(Env.Environment, agentQtable))
+		fmt.Printf("Decrypted cloud Qtable (policy / state value):\n%s", render.ASCII(Env.Environment, decryptedQtable))
+	}
+	if *svg_path != "" {
+		svg_qtable := decryptedQtable
+		if *svg_table == "agent" {
+			svg_qtable = agentQtable
+		}
+		if err := writeSVG(*svg_path, Env.Environment, svg_qtable); err != nil {
+			panic(err)
+		}
+		fmt.Println("Heatmap written to", *svg_path)
+	}
+}
+
+// シードを設定できる環境 (完全観測ではNonStationaryEnv，部分観測ではPartialObsEnv)
+type seededEnv interface {
+	environment.Env
+	Seed(seed int64)
+}
+
+// ベクトル化環境の全てのコピーで合計vec.Num()エピソードが終了するまで学習を進め，ゴール数と終了したエピソード数を返す
+// 途中のコピーの状態はstatesに，方策オン型の学習で選択済みの次の行動はactionsに残し (未選択は-1)，次の呼び出しで続きから進める
+// 到達したゴール地点はgoal_countsに加算する
+func runVectorizedEpisodes(vec *environment.VecEnv, states []int, actions []int, agt *agent.Agent, keyTools party.BfvKeyTools, encryptedQtable []*rlwe.Ciphertext, goal_counts map[position.Position]int) (float64, int) {
+	goal_count := 0.0
+	finished := 0
+
+	for finished < vec.Num() {
+		// 行動が未選択のコピーの行動を1回の秘匿計算の要求で選択する
+		pending := []int{}
+		for i, action := range actions {
+			if action < 0 {
+				pending = append(pending, i)
+			}
+		}
+		if len(pending) > 0 {
+			pending_states := make([]int, len(pending))
+			for k, i := range pending {
+				pending_states[k] = states[i]
+			}
+			for k, action := range agt.SecureSelectActions(pending_states, keyTools, encryptedQtable) {
+				actions[pending[k]] = action
+			}
+		}
+
+		next_states, rewards, terminateds, truncateds, infos := vec.Step(actions)
+
+		// 自動リセットされたコピーは，リセット前の状態で学習する
+		learn_states := make([]int, len(actions))
+		for i := range actions {
+			learn_states[i] = next_states[i]
+			if terminateds[i] || truncateds[i] {
+				learn_states[i] = infos[i][environment.INFO_FINAL_OBSERVATION].(int)
+				if infos[i][environment.INFO_GOAL] == true {
+					goal_count++
+					goal_counts[infos[i][environment.INFO_GOAL_POSITION].(position.Position)]++
+				}
+				finished++
+			}
+		}
+
+		// 方策オン型の学習では，全てのコピーの次の行動 (リセットされたコピーでは初期状態の行動) を1回の要求で選択する
+		// 打ち切られたコピーの目標値に使用するリセット前の状態の行動も同じ要求で選択する
+		next_actions := make([]int, len(actions))
+		target_actions := make([]int, len(actions))
+		for i := range next_actions {
+			next_actions[i] = -1
+			target_actions[i] = -1
+		}
+		if agt.Learner.OnPolicy() {
+			query := append([]int{}, next_states...)
+			truncated_indices := []int{}
+			for i := range actions {
+				if truncateds[i] {
+					query = append(query, learn_states[i])
+					truncated_indices = append(truncated_indices, i)
+				}
+			}
+			selected := agt.SecureSelectActions(query, keyTools, encryptedQtable)
+			copy(next_actions, selected[:len(actions)])
+			copy(target_actions, next_actions)
+			for k, i := range truncated_indices {
+				target_actions[i] = selected[len(actions)+k]
+			}
+		}
+
+		for i := range actions {
+			agt.Learn(agent.Transition{State: states[i], Action: actions[i], Reward: rewards[i], NextState: learn_states[i], NextAction: target_actions[i], Terminated: terminateds[i], Truncated: truncateds[i]}, keyTools, encryptedQtable)
+		}
+
+		copy(states, next_states)
+		copy(actions, next_actions)
+	}
+
+	return goal_count, finished
+}
+
+// 湖が変化したエピソードごとに，変化前の成功率と，変化後に成功率がその水準まで回復するのに要したエピソード数をCSVに書き出す
+// 回復は直近ADAPT_WINDOWエピソードの平均成功率で判定する (回復しなかった場合は-1)
+func writeMapChanges(filename string, schedule environment.Schedule, episode_success_rates []float64) {
+	file, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
+	writer.Write([]string{"Episode", "Changes", "Success Rate Before", "Recovery Episodes"})
+
+	windowMean := func(from int, to int) float64 {
+		sum := 0.0
+		for episode := from; episode < to; episode++ {
+			sum += episode_success_rates[episode]
+		}
+		return sum / float64(to-from)
+	}
+
+	for episode := range episode_success_rates {
+		changes := []string{}
+		for _, change := range schedule.ChangesAt(episode) {
+			changes = append(changes, change.String())
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		before := windowMean(max(0, episode-ADAPT_WINDOW), max(1, episode))
+		recovery := -1
+		for end := episode + ADAPT_WINDOW; end <= len(episode_success_rates); end++ {
+			if windowMean(end-ADAPT_WINDOW, end) >= before {
+				recovery = end - episode
+				break
+			}
+		}
+
+		fmt.Printf("Map changed at episode %d (%s): success rate before %.2f, recovered after %d episodes\n", episode, strings.Join(changes, "; "), before, recovery)
+		writer.Write([]string{fmt.Sprintf("%d", episode), strings.Join(changes, "; "), fmt.Sprintf("%.2f", before), fmt.Sprintf("%d", recovery)})
+	}
+}
+
+// 記録した軌跡を再生して決定性を検証する．renderがtrueなら各ステップの湖を描画する
+func replayTrajectory(replay_path string, show_render bool) {
+	replayed, err := trajectory.Replay(replay_path, func(env *environment.Environment, step trajectory.Step) {
+		if !show_render {
+			return
+		}
+		fmt.Printf("trial %d, agent %d, episode %d, t %d: action %s, reward %d\n", step.Trial, step.Agent, step.Episode, step.T, env.ActionSymbol(step.Action), step.Reward)
+		fmt.Print(render.ASCII(env, nil))
+	})
+	if err != nil {
+		fmt.Printf("Replay failed after %d steps: %v\n", replayed, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Replayed %d steps: all transitions match the recording\n", replayed)
+}
+
+// -load-qtableで指定した平文のQテーブルを読み込み，現在の環境・エージェントで使えるかを検査する
+func loadPlaintextQtable(path string, metadata qtable.Metadata, agt *agent.Agent) (qtable.Plaintext, error) {
+	loaded, err := qtable.LoadPlaintext(path)
+	if err != nil {
+		return qtable.Plaintext{}, err
+	}
+	if err := loaded.Check(metadata); err != nil {
+		return qtable.Plaintext{}, err
+	}
+	if err := loaded.CheckShape(agt.GetStateNum(), agt.GetActionNum(), agt.IsDouble()); err != nil {
+		return qtable.Plaintext{}, err
+	}
+	// 暗号化できない値があるとクラウドのQテーブルが読み込んだQテーブルと一致しなくなる
+	for _, table := range [][][]float64{loaded.Qtable, loaded.QtableB} {
+		for state, row := range table {
+			for action, Q := range row {
+				if _, clamped := utils.EncodeQ(Q, agt.SummedQtables()); clamped {
+					return qtable.Plaintext{}, fmt.Errorf("Q value %f of state %d, action %d is outside the encodable range", Q, state, action)
+				}
+			}
+		}
+	}
+	return loaded, nil
+}
+
+// 読み込んだ暗号化されたQテーブル
+type loadedCloudQtable struct {
+	params    bfv.Parameters
+	sk        *rlwe.SecretKey
+	table     []*rlwe.Ciphertext
+	decrypted [][]float64 // 保存した秘密鍵で復号したQ値 (エージェントのQテーブルの初期値に使用する)
+}
+
+// -load-cloudで指定した暗号化されたQテーブルを読み込み，現在の環境・エージェントで使えるかを検査する
+// 続きの学習では保存した暗号文を更新するので，秘密鍵も保存されている必要がある
+func loadCloudQtable(path string, metadata qtable.Metadata, agt *agent.Agent) (loadedCloudQtable, error) {
+	loaded, err := qtable.LoadEncrypted(path)
+	if err != nil {
+		return loadedCloudQtable{}, err
+	}
+	if err := loaded.Check(metadata); err != nil {
+		return loadedCloudQtable{}, err
+	}
+	if len(loaded.Ciphertexts) != agt.EncryptedQtableRows() || loaded.ActionNum != agt.GetActionNum() {
+		return loadedCloudQtable{}, fmt.Errorf("the table has %d rows and %d actions, but the agent needs %d rows and %d actions",
+			len(loaded.Ciphertexts), loaded.ActionNum, agt.EncryptedQtableRows(), agt.GetActionNum())
+	}
+
+	params, err := loaded.Parameters()
+	if err != nil {
+		return loadedCloudQtable{}, err
+	}
+	// 平文の法が異なると固定小数点表現(utils.MapInteger)から正しく値を戻せない
+	if params.T() != utils.T {
+		return loadedCloudQtable{}, fmt.Errorf("the table was encrypted with plaintext modulus %d, but the Q value encoding uses %d", params.T(), utils.T)
+	}
+	sk, err := loaded.Key(params)
+	if err != nil {
+		return loadedCloudQtable{}, err
+	}
+	table, err := loaded.Table()
+	if err != nil {
+		return loadedCloudQtable{}, err
+	}
+
+	decrypted := pprl.DecryptQtableWithBFV(params, bfv.NewEncoder(params), bfv.NewDecryptor(params, sk), agt.GetActionNum(), table)
+	return loadedCloudQtable{params: params, sk: sk, table: table, decrypted: decrypted}, nil
+}
+
+// 読み込んだQテーブルの貪欲方策を学習せずに評価する
+// EVAL_EPISODESエピソードの成功率と，貪欲方策の経路を表示する
+func evaluateLoadedQtable(env seededEnv, base *environment.NonStationaryEnv, agt *agent.Agent, show_render bool) {
+	goal_count := 0
+	for i := 0; i < EVAL_EPISODES; i++ {
+		// 同じ場所に留まり続ける方策でも，環境の最大ステップ数(MaxSteps)で打ち切られる
+		state := env.Reset()
+		for {
+			next_state, _, terminated, truncated, info := env.Step(agt.GreedyAction(state))
+			if terminated || truncated {
+				if info[environment.INFO_GOAL] == true {
+					goal_count++
+				}
+				break
+			}
+			state = next_state
+		}
+	}
+	fmt.Printf("Greedy policy success rate: %.2f%% (%d/%d episodes)\n", float64(goal_count)/float64(EVAL_EPISODES)*100.0, goal_count, EVAL_EPISODES)
+
+	agt.ShowOptimalPath(env)
+	if show_render {
+		agentQtable := agt.PolicyQtable()
+		if partial, ok := env.(*environment.PartialObsEnv); ok {
+			agentQtable = partial.StateTable(agentQtable)
+		}
+		fmt.Printf("Loaded Qtable (policy / state value):\n%s", render.ASCII(base.Environment, agentQtable))
+	}
+}
+
+// 状態価値のヒートマップをSVGファイルに書き出す
+func writeSVG(svg_path string, env *environment.Environment, qtable [][]float64) error {
+	svg_file, err := os.Create(svg_path)
+	if err != nil {
+		return err
+	}
+	defer svg_file.Close()
+
+	return render.WriteSVG(svg_file, env, qtable)
+}
+
+// マップファイルが存在すればファイルから，存在しなければ文字列そのものをマップとして読み込む
+func loadLake(map_path string) (frozenlake.FrozenLake, error) {
+	if _, err := os.Stat(map_path); err == nil {
+		return frozenlake.LoadLakeMap(map_path)
+	}
+	return frozenlake.ParseLakeMap(map_path)
 }
 
 func calcMSE(agt *agent.Agent, encryptedQtable []*rlwe.Ciphertext, params bfv.Parameters, encoder bfv.Encoder, decryptor rlwe.Decryptor) float64 {
@@ -257,24 +1045,16 @@ func calcMSE(agt *agent.Agent, encryptedQtable []*rlwe.Ciphertext, params bfv.Pa
 	return mse
 }
 
-func ShowDecryptedQTable(agt *agent.Agent, encryptedQtable []*rlwe.Ciphertext, params bfv.Parameters, encoder bfv.Encoder, decryptor rlwe.Decryptor) {
-	// 暗号化されたQテーブルの各要素を復号して表示
+func ShowDecryptedQTable(env environment.Env, agt *agent.Agent, encryptedQtable []*rlwe.Ciphertext, params bfv.Parameters, encoder bfv.Encoder, decryptor rlwe.Decryptor) {
+	// 暗号化されたQテーブルの各要素を復号して表示 (スロット数は行動数に合わせる)
 	fmt.Println("Decrypted Qtable:")
-	for i, encryptedValue := range encryptedQtable {
-		// ここで復号プロセスを実行
-		decryptedValue := doublenc.BFVdec(params, encoder, decryptor, encryptedValue)
-		decryptedValue_float64 := make([]float64, 4)
-
-		// [0, 2N] -> [-N, N] +  係数の除去
-		for j := 0; j < 4; j++ {
-			Q_new_int64 := utils.UnmapInteger(decryptedValue[j])
-			decryptedValue_float64[j] = float64(Q_new_int64) / utils.Q_int_coeff
+	decryptedQtable := pprl.DecryptQtableWithBFV(params, encoder, decryptor, agt.GetActionNum(), encryptedQtable)
+	for i, decryptedValue_float64 := range decryptedQtable {
+		fmt.Printf("State %s: ", environment.StateLabel(env, i))
+		for action, qValue := range decryptedValue_float64 {
+			fmt.Printf("%s: %f ", environment.ActionLabel(env, action), qValue)
 		}
-		// 復号された値を表示
-		height := int(math.Sqrt(float64(agt.GetStateNum())))
-		x := i % height
-		y := i / height
-		fmt.Printf("State [Y: %d, X: %d]: %f\n", y, x, decryptedValue_float64)
+		fmt.Println()
 	}
 }
 
@@ -302,26 +1082,26 @@ func evaluateGreedyActionAtEpisodes(now_episode int, env *environment.Environmen
 	goal_count := 0 // エピソードでのゴール到達回数をカウント
 	trials := 100   // 評価のために各エピソードを何回実行するか
 
+	// 同じ場所に留まり続ける方策でも終了するように，環境の最大ステップ数(MaxSteps)が無制限の場合はMAX_STEPSで打ち切る
+	max_steps := env.MaxSteps
+	if max_steps <= 0 {
+		max_steps = MAX_STEPS
+	}
+
 	for i := 0; i < trials; i++ {
 		state := env.Reset()
-		cnt := 0
-		for {
+		for cnt := 0; cnt < max_steps; cnt++ {
 			action := agt.GreedyAction(state) // 学習済みのQテーブルを使用して最適な行動を選択
-			next_state, _, done := env.Step(action)
+			next_state, _, terminated, truncated, info := env.Step(action)
 
-			if done {
-				if next_state == env.GoalPos {
+			if terminated || truncated {
+				if info[environment.INFO_GOAL] == true {
 					goal_count++
 				}
 				break
 			}
 
-			if cnt > 100 {
-				break
-			}
-
 			state = next_state
-			cnt++
 		}
 	}
 
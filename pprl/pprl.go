@@ -4,6 +4,7 @@ import (
 	"crypto/rsa"
 	"fmt"
 	"pprlgoFrozenLake/doublenc"
+	"pprlgoFrozenLake/utils"
 
 	"github.com/tuneinsight/lattigo/v4/bfv"
 	"github.com/tuneinsight/lattigo/v4/ckks"
@@ -260,3 +261,176 @@ func SecureActionSelectionWithBFV(params bfv.Parameters, encoder bfv.Encoder, en
 
 	return result
 }
+
+// クラウド上の暗号化されたQテーブルを復号し，固定小数点表現から実数値のQテーブルに戻す
+func DecryptQtableWithBFV(params bfv.Parameters, encoder bfv.Encoder, decryptor rlwe.Decryptor, Na int, EncryptedQtable []*rlwe.Ciphertext) [][]float64 {
+	decryptedQtable := make([][]float64, len(EncryptedQtable))
+	for i, encryptedValue := range EncryptedQtable {
+		decryptedMessage := doublenc.BFVdec(params, encoder, decryptor, encryptedValue)
+
+		// [0, 2N] -> [-N, N] +  係数の除去
+		decryptedQtable[i] = make([]float64, Na)
+		for j := 0; j < Na; j++ {
+			Q_new_int64 := utils.UnmapInteger(decryptedMessage[j])
+			decryptedQtable[i][j] = float64(Q_new_int64) / utils.Q_int_coeff
+		}
+	}
+
+	return decryptedQtable
+}
+
+// 1つの暗号文に詰められる行動選択の問い合わせの数
+// BFVのスロットは2行×(N/2)列に並び，各行の中で列を回転できるので，各行にN/2/Na個ずつ詰める
+// 行動数がN/2を超える場合は行の中で回転できないので，1つの暗号文に1つの問い合わせとする
+func SelectionQueriesPerCiphertext(params bfv.Parameters, Na int) int {
+	perRow := params.N() / 2 / Na
+	if perRow == 0 {
+		return 1
+	}
+	return 2 * perRow
+}
+
+// k番目の問い合わせの行動価値が入る暗号文の番号と，先頭のスロット
+func SelectionQuerySlot(params bfv.Parameters, Na int, k int) (int, int) {
+	perCiphertext := SelectionQueriesPerCiphertext(params, Na)
+	if perCiphertext == 1 {
+		return k, 0
+	}
+	perRow := perCiphertext / 2
+	block := k % perCiphertext
+	return k / perCiphertext, (block/perRow)*(params.N()/2) + (block%perRow)*Na
+}
+
+// 問い合わせを詰めるためにクラウドがQテーブルの行を回転する列の回転量 (回転鍵の生成に使用する．行の入れ替えも必要)
+func SelectionRotations(params bfv.Parameters, Na int) []int {
+	rotations := []int{}
+	for j := 1; j < SelectionQueriesPerCiphertext(params, Na)/2; j++ {
+		rotations = append(rotations, -j*Na) // 負の回転量は右への回転
+	}
+	return rotations
+}
+
+// Qテーブルの行(スロット0〜Na-1)を，blocks個の問い合わせの位置(SelectionQuerySlot)に複製する
+func replicateQtableRow(params bfv.Parameters, evaluator bfv.Evaluator, Na int, blocks int, row *rlwe.Ciphertext) *rlwe.Ciphertext {
+	perRow := SelectionQueriesPerCiphertext(params, Na) / 2
+	if perRow == 0 {
+		return row
+	}
+
+	replicated := row
+	for j := 1; j < perRow && j < blocks; j++ {
+		replicated = evaluator.AddNew(replicated, evaluator.RotateColumnsNew(row, -j*Na))
+	}
+	// 2行目の問い合わせには，1行目の複製を行ごと入れ替えて使う
+	if blocks > perRow {
+		replicated = evaluator.AddNew(replicated, evaluator.RotateRowsNew(replicated))
+	}
+	return replicated
+}
+
+// 複数の状態に対する行動選択の問い合わせを1回の要求で処理する
+// v_ts[k]はk番目の問い合わせの状態を表すone-hotベクトルで，SelectionQueriesPerCiphertext個ずつの問い合わせを1つの暗号文に詰める
+// 状態ごとに，各問い合わせのスロットにその問い合わせのv_tの値を並べたマスクを1つだけ二重暗号化して送り，
+// クラウドは各行を問い合わせのスロットに複製してマスクと掛け合わせて足し合わせる
+// 戻り値は詰めた暗号文で，k番目の問い合わせの行動価値はSelectionQuerySlot(params, Na, k)の位置に入る
+// 複数のQテーブルを渡した場合は同じマスクで各テーブルの行動価値を求め，その和を返す (Double Q学習)
+func SecureActionSelectionsWithBFV(params bfv.Parameters, encoder bfv.Encoder, encryptor rlwe.Encryptor, decryptor rlwe.Decryptor, evaluator bfv.Evaluator, publicKey *rsa.PublicKey, privateKey *rsa.PrivateKey, v_ts [][]float64, Nv int, Na int, EncryptedQtables ...[]*rlwe.Ciphertext) []*rlwe.Ciphertext {
+	MaskName := "SelectionMaskName"
+	perCiphertext := SelectionQueriesPerCiphertext(params, Na)
+
+	results := []*rlwe.Ciphertext{}
+	for first := 0; first < len(v_ts); first += perCiphertext {
+		queries := v_ts[first:]
+		if len(queries) > perCiphertext {
+			queries = queries[:perCiphertext]
+		}
+
+		temp := make([][][]uint8, Nv)
+		for i := 0; i < Nv; i++ {
+			mask := make([]uint64, params.N())
+			for k, v_t := range queries {
+				if v_t[i] != 1 {
+					continue
+				}
+				_, slot := SelectionQuerySlot(params, Na, k)
+				for j := 0; j < Na; j++ {
+					mask[slot+j] = 1
+				}
+			}
+			temp[i] = doublenc.DEencBFV(params, encoder, encryptor, publicKey, mask, fmt.Sprintf(MaskName+"_%d", i))
+		}
+
+		zeros := make([]uint64, Na)
+		result := doublenc.BFVenc(params, encoder, encryptor, zeros)
+		for i := 0; i < Nv; i++ {
+			vt := doublenc.RSAdec2(privateKey, temp[i])
+			for _, EncryptedQtable := range EncryptedQtables {
+				masked := evaluator.MulNew(vt, replicateQtableRow(params, evaluator, Na, len(queries), EncryptedQtable[i]))
+				evaluator.Relinearize(masked, masked)
+				result = evaluator.AddNew(result, masked)
+			}
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// 2つの暗号化されたQテーブル(Double Q学習のQ_AとQ_B)の同じ状態の行を足し合わせた行動価値を求める
+// 和はクラウド上で暗号文のまま計算するので，エージェントは各テーブルの値を知ることはない
+// 和はTを法として計算されるので，各テーブルのQ値はutils.EncodeQ(Q, 2)で和が[-N, N]に収まる範囲にしておくこと
+func SecureSumActionSelectionWithBFV(params bfv.Parameters, encoder bfv.Encoder, encryptor rlwe.Encryptor, decryptor rlwe.Decryptor, evaluator bfv.Evaluator, publicKey *rsa.PublicKey, privateKey *rsa.PrivateKey, v_t []float64, Nv int, Na int, EncryptedQtableA []*rlwe.Ciphertext, EncryptedQtableB []*rlwe.Ciphertext) *rlwe.Ciphertext {
+	resultA := SecureActionSelectionWithBFV(params, encoder, encryptor, decryptor, evaluator, publicKey, privateKey, v_t, Nv, Na, EncryptedQtableA)
+	resultB := SecureActionSelectionWithBFV(params, encoder, encryptor, decryptor, evaluator, publicKey, privateKey, v_t, Nv, Na, EncryptedQtableB)
+	return evaluator.AddNew(resultA, resultB)
+}
+
+// 一括更新するQテーブルの1要素
+type QtableUpdate struct {
+	State  int
+	Action int
+	Q_new  uint64 // 新しいQ値 (utils.MapIntegerで固定小数点表現に変換したもの)
+}
+
+// 複数の(状態, 行動, 新しいQ値)をまとめて1回の要求でクラウドのQテーブルに反映する
+// 状態ごとに更新する行動のマスクと新しいQ値のベクトルを二重暗号化して送り，各行を Q + mask*Q_new - mask*Q で置き換える
+// 更新しない状態にも0のマスクを送るので，クラウドからはどの状態・行動を更新したかは分からない
+func SecureBatchQtableUpdatingWithBFV(params bfv.Parameters, encoder bfv.Encoder, encryptor rlwe.Encryptor, decryptor rlwe.Decryptor, evaluator bfv.Evaluator, publicKey *rsa.PublicKey, privateKey *rsa.PrivateKey, updates []QtableUpdate, Nv int, Na int, EncryptedQtable []*rlwe.Ciphertext) {
+	MaskName := "MaskName"
+	QnewName := "QnewName"
+
+	masks := make([][]uint64, Nv)
+	Q_news := make([][]uint64, Nv)
+	for i := 0; i < Nv; i++ {
+		masks[i] = make([]uint64, Na)
+		Q_news[i] = make([]uint64, Na)
+	}
+	// 同じ要素を複数回更新する場合は後の値を優先する
+	for _, update := range updates {
+		masks[update.State][update.Action] = 1
+		Q_news[update.State][update.Action] = update.Q_new
+	}
+
+	for i := 0; i < Nv; i++ {
+		DE_mask := doublenc.DEencBFV(params, encoder, encryptor, publicKey, masks[i], fmt.Sprintf(MaskName+"_%d", i))
+		DE_Q_new := doublenc.DEencBFV(params, encoder, encryptor, publicKey, Q_news[i], fmt.Sprintf(QnewName+"_%d", i))
+
+		fhe_mask := doublenc.RSAdec2(privateKey, DE_mask)
+		fhe_Q_new := doublenc.RSAdec2(privateKey, DE_Q_new)
+
+		// make Qnew
+		fhe_mask_Qnew := evaluator.MulNew(fhe_mask, fhe_Q_new)
+		evaluator.Relinearize(fhe_mask_Qnew, fhe_mask_Qnew)
+
+		// make Qold
+		fhe_mask_Qold := evaluator.MulNew(fhe_mask, EncryptedQtable[i])
+		evaluator.Relinearize(fhe_mask_Qold, fhe_mask_Qold)
+
+		// SecureQtableUpdatingWithBFVと同様に，ノイズを抑えるため再暗号化する
+		re_fhe_mask_Qnew := doublenc.BFVenc(params, encoder, encryptor, doublenc.BFVdec(params, encoder, decryptor, fhe_mask_Qnew))
+		re_fhe_mask_Qold := doublenc.BFVenc(params, encoder, encryptor, doublenc.BFVdec(params, encoder, decryptor, fhe_mask_Qold))
+
+		EncryptedQtable[i] = evaluator.AddNew(EncryptedQtable[i], re_fhe_mask_Qnew)
+		EncryptedQtable[i] = evaluator.SubNew(EncryptedQtable[i], re_fhe_mask_Qold)
+	}
+}
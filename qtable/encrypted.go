@@ -0,0 +1,112 @@
+package qtable
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/tuneinsight/lattigo/v4/bfv"
+	"github.com/tuneinsight/lattigo/v4/rlwe"
+)
+
+// クラウド上の暗号化されたQテーブル
+// 各行の暗号文はMarshalBinary()のバイト列で保存し，復元に必要なBFVのパラメータも保存する
+// 秘密鍵を含めない場合は，このファイルだけでは復号も続きの学習もできない
+type Encrypted struct {
+	Metadata
+	Params      bfv.ParametersLiteral `json:"params"`
+	ActionNum   int                   `json:"action_num"`
+	Ciphertexts [][]byte              `json:"ciphertexts"`          // 1行(状態)につき1つの暗号文 (Double Q学習では前半がQ_A，後半がQ_B)
+	SecretKey   []byte                `json:"secret_key,omitempty"` // 秘密鍵 (保存しない場合は空)
+}
+
+// 暗号化されたQテーブルを保存できる形式に変換する (skがnilの場合は秘密鍵を含めない)
+func NewEncrypted(metadata Metadata, params bfv.Parameters, actionNum int, encryptedQtable []*rlwe.Ciphertext, sk *rlwe.SecretKey) (Encrypted, error) {
+	encrypted := Encrypted{
+		Metadata:    metadata,
+		Params:      params.ParametersLiteral(),
+		ActionNum:   actionNum,
+		Ciphertexts: make([][]byte, len(encryptedQtable)),
+	}
+
+	for i, ciphertext := range encryptedQtable {
+		data, err := ciphertext.MarshalBinary()
+		if err != nil {
+			return Encrypted{}, fmt.Errorf("row %d: %w", i, err)
+		}
+		encrypted.Ciphertexts[i] = data
+	}
+
+	if sk != nil {
+		data, err := sk.MarshalBinary()
+		if err != nil {
+			return Encrypted{}, err
+		}
+		encrypted.SecretKey = data
+	}
+
+	return encrypted, nil
+}
+
+// 保存したパラメータを復元する
+func (e Encrypted) Parameters() (bfv.Parameters, error) {
+	return bfv.NewParametersFromLiteral(e.Params)
+}
+
+// 保存した暗号文を復元する
+func (e Encrypted) Table() ([]*rlwe.Ciphertext, error) {
+	encryptedQtable := make([]*rlwe.Ciphertext, len(e.Ciphertexts))
+	for i, data := range e.Ciphertexts {
+		encryptedQtable[i] = new(rlwe.Ciphertext)
+		if err := encryptedQtable[i].UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+	}
+	return encryptedQtable, nil
+}
+
+// 保存した秘密鍵を復元する (保存していない場合はエラー)
+func (e Encrypted) Key(params bfv.Parameters) (*rlwe.SecretKey, error) {
+	if len(e.SecretKey) == 0 {
+		return nil, fmt.Errorf("the table was saved without the secret key")
+	}
+
+	sk := rlwe.NewSecretKey(params.Parameters)
+	if err := sk.UnmarshalBinary(e.SecretKey); err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
+
+// 暗号化されたQテーブルをJSONで保存する (暗号文と秘密鍵はbase64で書き出される)
+func SaveEncrypted(path string, encrypted Encrypted) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(file).Encode(encrypted); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// SaveEncrypted()で保存した暗号化されたQテーブルを読み込む
+func LoadEncrypted(path string) (Encrypted, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Encrypted{}, err
+	}
+	defer file.Close()
+
+	var encrypted Encrypted
+	if err := json.NewDecoder(file).Decode(&encrypted); err != nil {
+		return Encrypted{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if encrypted.Version != FORMAT_VERSION {
+		return Encrypted{}, fmt.Errorf("%s: unsupported format version %d (expected %d)", path, encrypted.Version, FORMAT_VERSION)
+	}
+
+	return encrypted, nil
+}
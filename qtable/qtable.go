@@ -0,0 +1,242 @@
+package qtable
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pprlgoFrozenLake/environment"
+	"strconv"
+	"strings"
+)
+
+// 保存するファイルの形式のバージョン (形式を変更した場合は上げる)
+// 2: メタデータに行動の集合の名前とクラウドで足し合わせるQテーブルの数を記録する
+const FORMAT_VERSION = 2
+
+// CSVファイルの先頭行でメタデータ(JSON)の前に置く文字列
+const CSV_METADATA_PREFIX = "# metadata: "
+
+// CSVファイルのtable列の値 (Double Q学習ではQ_AとQ_Bの2つのQテーブルを保存する)
+const (
+	TABLE_A = "A"
+	TABLE_B = "B"
+)
+
+// Qテーブルを学習した環境などの情報 (読み込んだQテーブルが現在の環境で使えるかの検査に使用する)
+type Metadata struct {
+	Version     int                          `json:"version"`
+	Env         environment.Spec             `json:"env"`         // 学習した環境 (湖・報酬・行動など)
+	Observation environment.ObservationModel `json:"observation"` // 部分観測の場合はQテーブルの行は観測IDになる
+	Learner     string                       `json:"learner"`     // 学習アルゴリズムの名前
+	ActionSet   string                       `json:"action_set"`  // 行動の集合の名前 (-actionsオプションの値)
+	Tables      int                          `json:"tables"`      // クラウドで足し合わせて行動を選ぶQテーブルの数 (Double Q学習では2)
+}
+
+func NewMetadata(spec environment.Spec, observation environment.ObservationModel, learner string, actionSet string, tables int) Metadata {
+	return Metadata{Version: FORMAT_VERSION, Env: spec, Observation: observation, Learner: learner, ActionSet: actionSet, Tables: tables}
+}
+
+// 保存したQテーブルを現在の環境(current)で使えるかを検査する (湖・行動・観測・Qテーブルの数が同じであること)
+// 報酬や滑りやすさ，Qテーブルの数が同じ学習アルゴリズムは異なっていてもよい (別の設定で続きを学習できるようにする)
+func (m Metadata) Check(current Metadata) error {
+	if m.Env.Lake != current.Env.Lake {
+		return fmt.Errorf("the table was learned on a different lake:\n%s", m.Env.Lake)
+	}
+	if m.ActionSet != current.ActionSet {
+		return fmt.Errorf("the table was learned with action set %q, but the environment uses %q", m.ActionSet, current.ActionSet)
+	}
+	if len(m.Env.Actions) != len(current.Env.Actions) {
+		return fmt.Errorf("the table has %d actions, but the environment has %d", len(m.Env.Actions), len(current.Env.Actions))
+	}
+	// 同じ数の行動でも，列の順序が異なると別の行動のQ値として読み込んでしまう
+	for i, action := range m.Env.Actions {
+		if action.Name != current.Env.Actions[i].Name {
+			return fmt.Errorf("action %d of the table is %q, but the environment has %q", i, action.Name, current.Env.Actions[i].Name)
+		}
+	}
+	// 各Qテーブルの値の範囲(utils.EncodeQ)と暗号文の並びは足し合わせるQテーブルの数で決まる
+	if m.Tables != current.Tables {
+		return fmt.Errorf("the table was learned by %s with %d summed Q-tables, but the learner %s uses %d", m.Learner, m.Tables, current.Learner, current.Tables)
+	}
+	if m.Observation != current.Observation {
+		return fmt.Errorf("the table was learned with observation %+v, but the environment uses %+v", m.Observation, current.Observation)
+	}
+	return nil
+}
+
+// 平文のQテーブル (エージェントのQテーブル)
+type Plaintext struct {
+	Metadata
+	Qtable  [][]float64 `json:"qtable"`
+	QtableB [][]float64 `json:"qtable_b,omitempty"` // Double Q学習の2つ目のQテーブル
+}
+
+// 行数・行動数が一致するかを検査する (double: Double Q学習の2つ目のQテーブルが必要か)
+func (p Plaintext) CheckShape(rows int, actions int, double bool) error {
+	tables := [][][]float64{p.Qtable}
+	if double {
+		if p.QtableB == nil {
+			return fmt.Errorf("the table has no second Q-table for double Q-learning")
+		}
+		tables = append(tables, p.QtableB)
+	}
+
+	for _, table := range tables {
+		if len(table) != rows {
+			return fmt.Errorf("the table has %d rows, but the agent has %d", len(table), rows)
+		}
+		for _, row := range table {
+			if len(row) != actions {
+				return fmt.Errorf("the table has a row with %d actions, but the agent has %d", len(row), actions)
+			}
+		}
+	}
+	return nil
+}
+
+// 平文のQテーブルを保存する (拡張子が.csvの場合はCSV，それ以外はJSON)
+func SavePlaintext(path string, table Plaintext) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if isCSV(path) {
+		err = writeCSV(file, table)
+	} else {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(table)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// SavePlaintext()で保存した平文のQテーブルを読み込む
+func LoadPlaintext(path string) (Plaintext, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Plaintext{}, err
+	}
+	defer file.Close()
+
+	var table Plaintext
+	if isCSV(path) {
+		table, err = readCSV(file)
+	} else {
+		err = json.NewDecoder(file).Decode(&table)
+	}
+	if err != nil {
+		return Plaintext{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if table.Version != FORMAT_VERSION {
+		return Plaintext{}, fmt.Errorf("%s: unsupported format version %d (expected %d)", path, table.Version, FORMAT_VERSION)
+	}
+
+	return table, nil
+}
+
+func isCSV(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".csv")
+}
+
+// 1行目にメタデータのJSONをコメントとして書き，以降は table,state,各行動の名前 の表にする
+func writeCSV(file *os.File, table Plaintext) error {
+	metadata, err := json.Marshal(table.Metadata)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	if _, err := writer.WriteString(CSV_METADATA_PREFIX + string(metadata) + "\n"); err != nil {
+		return err
+	}
+
+	csvWriter := csv.NewWriter(writer)
+	header := []string{"table", "state"}
+	for _, action := range table.Env.Actions {
+		header = append(header, action.Name)
+	}
+	csvWriter.Write(header)
+
+	for _, named := range []struct {
+		name  string
+		table [][]float64
+	}{{TABLE_A, table.Qtable}, {TABLE_B, table.QtableB}} {
+		for state, row := range named.table {
+			record := []string{named.name, strconv.Itoa(state)}
+			for _, qValue := range row {
+				record = append(record, strconv.FormatFloat(qValue, 'g', -1, 64)) // 読み込んだときに同じ値に戻るように最短の表現で書く
+			}
+			csvWriter.Write(record)
+		}
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
+
+func readCSV(file *os.File) (Plaintext, error) {
+	reader := bufio.NewReader(file)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return Plaintext{}, err
+	}
+	if !strings.HasPrefix(line, CSV_METADATA_PREFIX) {
+		return Plaintext{}, fmt.Errorf("the first line must start with %q", CSV_METADATA_PREFIX)
+	}
+
+	var table Plaintext
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, CSV_METADATA_PREFIX)), &table.Metadata); err != nil {
+		return Plaintext{}, err
+	}
+
+	records, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		return Plaintext{}, err
+	}
+	if len(records) == 0 {
+		return Plaintext{}, fmt.Errorf("missing header")
+	}
+
+	for _, record := range records[1:] {
+		row := make([]float64, len(record)-2)
+		for i := range row {
+			row[i], err = strconv.ParseFloat(record[i+2], 64)
+			if err != nil {
+				return Plaintext{}, err
+			}
+		}
+
+		// 状態は行の順に並んでいるものとする
+		state, err := strconv.Atoi(record[1])
+		if err != nil {
+			return Plaintext{}, err
+		}
+		switch record[0] {
+		case TABLE_A:
+			if state != len(table.Qtable) {
+				return Plaintext{}, fmt.Errorf("table %s: expected state %d, got %d", TABLE_A, len(table.Qtable), state)
+			}
+			table.Qtable = append(table.Qtable, row)
+		case TABLE_B:
+			if state != len(table.QtableB) {
+				return Plaintext{}, fmt.Errorf("table %s: expected state %d, got %d", TABLE_B, len(table.QtableB), state)
+			}
+			table.QtableB = append(table.QtableB, row)
+		default:
+			return Plaintext{}, fmt.Errorf("unknown table %q (options: %s, %s)", record[0], TABLE_A, TABLE_B)
+		}
+	}
+
+	return table, nil
+}
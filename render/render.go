@@ -0,0 +1,149 @@
+package render
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"pprlgoFrozenLake/environment"
+	"pprlgoFrozenLake/position"
+	"strings"
+)
+
+const (
+	SVG_CELL_SIZE = 64  // SVGの1マスの大きさ(px)
+	NO_POLICY     = "·" // 全ての行動のQ値が等しい (未学習の) 状態に表示する記号
+)
+
+// セルの種類を表す文字 (GymnasiumのFrozenLakeと同じ S/F/H/G と薄氷の T)
+func cellKind(env *environment.Environment, pos position.Position) string {
+	if pos == env.StartPos {
+		return "S"
+	}
+	return env.CellKind(pos)
+}
+
+// 穴またはゴール (方策や価値を表示しないセル)
+func isTerminal(env *environment.Environment, pos position.Position) bool {
+	return env.IsGoal(pos) || env.IsHole(pos)
+}
+
+// Qテーブルの1行から貪欲方策の行動と状態価値(最大のQ値)を求める
+// 全ての行動のQ値が等しい場合は行動を-1とする
+func greedy(actions []float64) (int, float64) {
+	maxAction := 0
+	allEqual := true
+	for action, qValue := range actions {
+		if qValue != actions[0] {
+			allEqual = false
+		}
+		if qValue > actions[maxAction] {
+			maxAction = action
+		}
+	}
+
+	if allEqual {
+		return -1, actions[0]
+	}
+	return maxAction, actions[maxAction]
+}
+
+func policySymbol(env *environment.Environment, action int) string {
+	if action < 0 {
+		return NO_POLICY
+	}
+	return env.ActionSymbol(action)
+}
+
+// 湖とエージェントの位置，貪欲方策の矢印と状態価値をASCIIで描画する
+// qtableには平文のQテーブル(Agent.Qtable)か復号したクラウドのQテーブルを渡す (nilの場合は湖のみを描画する)
+// エージェントの現在位置は [ ] で囲んで表示する
+func ASCII(env *environment.Environment, qtable [][]float64) string {
+	var sb strings.Builder
+	for y := 0; y < env.Height(); y++ {
+		for x := 0; x < env.Width(); x++ {
+			pos := position.Position{Y: y, X: x}
+
+			cell := cellKind(env, pos)
+			if qtable != nil && !isTerminal(env, pos) {
+				action, value := greedy(qtable[env.PositionToState(pos)])
+				cell = fmt.Sprintf("%s%s%7.2f", cell, policySymbol(env, action), value)
+			} else if qtable != nil {
+				cell = fmt.Sprintf("%-9s", cell)
+			}
+
+			if pos == env.AgentPosition() {
+				sb.WriteString("[" + cell + "]")
+			} else {
+				sb.WriteString(" " + cell + " ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// 状態価値のヒートマップと貪欲方策の矢印をSVG形式で書き出す
+// 状態価値が正のセルは緑，負のセルは赤で塗り，絶対値が大きいほど濃くする
+func WriteSVG(w io.Writer, env *environment.Environment, qtable [][]float64) error {
+	// 色の濃さを正規化するため，終了状態以外の状態価値の絶対値の最大値を求める
+	maxAbs := 0.0
+	for state, actions := range qtable {
+		if isTerminal(env, env.StateToPosition(state)) {
+			continue
+		}
+		_, value := greedy(actions)
+		maxAbs = math.Max(maxAbs, math.Abs(value))
+	}
+
+	width := env.Width() * SVG_CELL_SIZE
+	height := env.Height() * SVG_CELL_SIZE
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\" font-family=\"sans-serif\" text-anchor=\"middle\">\n", width, height, width, height)
+
+	for state, actions := range qtable {
+		pos := env.StateToPosition(state)
+		left := pos.X * SVG_CELL_SIZE
+		top := pos.Y * SVG_CELL_SIZE
+		centerX := left + SVG_CELL_SIZE/2
+
+		kind := cellKind(env, pos)
+		switch kind {
+		case "H":
+			fmt.Fprintf(&sb, "  <rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"#263238\" stroke=\"#000\"/>\n", left, top, SVG_CELL_SIZE, SVG_CELL_SIZE)
+			fmt.Fprintf(&sb, "  <text x=\"%d\" y=\"%d\" font-size=\"20\" fill=\"#fff\">H</text>\n", centerX, top+SVG_CELL_SIZE/2+7)
+			continue
+		case "G":
+			fmt.Fprintf(&sb, "  <rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"#ffd54f\" stroke=\"#000\"/>\n", left, top, SVG_CELL_SIZE, SVG_CELL_SIZE)
+			fmt.Fprintf(&sb, "  <text x=\"%d\" y=\"%d\" font-size=\"20\">G</text>\n", centerX, top+SVG_CELL_SIZE/2+7)
+			continue
+		}
+
+		action, value := greedy(actions)
+		fmt.Fprintf(&sb, "  <rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\" stroke=\"#000\"/>\n", left, top, SVG_CELL_SIZE, SVG_CELL_SIZE, heatColor(value, maxAbs))
+		if kind == "S" || kind == "T" {
+			fmt.Fprintf(&sb, "  <text x=\"%d\" y=\"%d\" font-size=\"10\" text-anchor=\"start\">%s</text>\n", left+3, top+12, kind)
+		}
+		fmt.Fprintf(&sb, "  <text x=\"%d\" y=\"%d\" font-size=\"22\">%s</text>\n", centerX, top+SVG_CELL_SIZE/2+4, policySymbol(env, action))
+		fmt.Fprintf(&sb, "  <text x=\"%d\" y=\"%d\" font-size=\"11\">%.2f</text>\n", centerX, top+SVG_CELL_SIZE-8, value)
+	}
+
+	sb.WriteString("</svg>\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// 状態価値をヒートマップの色に変換 (正: 緑, 負: 赤, 0: 白)
+func heatColor(value float64, maxAbs float64) string {
+	if maxAbs == 0 {
+		return "#ffffff"
+	}
+
+	intensity := math.Min(math.Abs(value)/maxAbs, 1)
+	fade := int(255 * (1 - 0.75*intensity))
+	if value >= 0 {
+		return fmt.Sprintf("#%02x%02x%02x", fade, 255, fade)
+	}
+	return fmt.Sprintf("#%02x%02x%02x", 255, fade, fade)
+}
@@ -0,0 +1,168 @@
+package trajectory
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"pprlgoFrozenLake/environment"
+)
+
+// 記録ファイルの形式のバージョン (形式を変更した場合は上げる)
+// 2: 環境の設定に試行とエージェントの番号を記録する
+const FORMAT_VERSION = 2
+
+// 記録ファイル(JSONL)の1行の種類
+const (
+	RECORD_ENV  = "env"  // 以降のステップを記録した環境の設定
+	RECORD_STEP = "step" // 1ステップ分の遷移
+)
+
+// 記録ファイルの1行の最大サイズ (大きな湖の地図や変化の予定を含む環境の設定も読めるようにする)
+const MAX_RECORD_SIZE = 64 << 20
+
+// 1ステップ分の遷移 (ファイルを小さくするためにJSONのキーは短くする)
+type Step struct {
+	Trial       int     `json:"trial"`
+	Agent       int     `json:"agt"`
+	Episode     int     `json:"ep"`
+	T           int     `json:"t"` // エピソード内のステップ番号 (0から始まる)
+	State       int     `json:"s"`
+	Action      int     `json:"a"` // エージェントが選択した行動 (滑る前の行動)
+	Reward      int     `json:"r"`
+	NextState   int     `json:"ns"`
+	Terminated  bool    `json:"term"`
+	Truncated   bool    `json:"trunc"`
+	EpsilonDraw float64 `json:"eps"` // ε-greedyで探索するかの判定に使用した乱数
+}
+
+// 記録ファイルの1行
+type Record struct {
+	Type    string            `json:"type"`
+	Version int               `json:"v,omitempty"`
+	Trial   int               `json:"trial,omitempty"` // 環境の設定を記録した試行 (RECORD_ENVのみ)
+	Agent   int               `json:"agt,omitempty"`   // 環境の設定を記録したエージェント (RECORD_ENVのみ)
+	Env     *environment.Spec `json:"env,omitempty"`
+	Step    *Step             `json:"step,omitempty"`
+}
+
+// 環境を識別するキー (エージェントごとに環境を持つので，ステップは試行とエージェントの番号で環境を選ぶ)
+type envKey struct {
+	trial int
+	agent int
+}
+
+// 学習中の軌跡をJSONLファイルに書き出す
+type Recorder struct {
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := bufio.NewWriter(file)
+	return &Recorder{
+		file:    file,
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+	}, nil
+}
+
+// 試行trialでエージェントagentが以降に記録するステップを実行する環境の設定を記録する
+// 再生時に同じ遷移を再現するため，環境のシードを設定した直後に呼び出すこと
+func (r *Recorder) BeginEnv(trial int, agent int, spec environment.Spec) error {
+	return r.encoder.Encode(Record{Type: RECORD_ENV, Version: FORMAT_VERSION, Trial: trial, Agent: agent, Env: &spec})
+}
+
+func (r *Recorder) Record(step Step) error {
+	return r.encoder.Encode(Record{Type: RECORD_STEP, Step: &step})
+}
+
+func (r *Recorder) Close() error {
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// 記録された軌跡を環境で再実行し，記録と同じ遷移・報酬になるか(決定性)を検証する
+// onStepは再実行した各ステップの後に呼ばれる (再描画などに使用する．nilでもよい)
+// 湖の変化の予定が記録されている場合は，予定に従って湖を変化させながら再実行する
+// 複数のエージェントのステップが交互に記録されていても，試行とエージェントごとの環境で再実行する
+// 検証できたステップ数を返す
+func Replay(path string, onStep func(env *environment.Environment, step Step)) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	envs := map[envKey]*environment.NonStationaryEnv{}
+	replayed := 0
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 1<<20), MAX_RECORD_SIZE)
+	for line := 1; scanner.Scan(); line++ {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return replayed, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+
+		switch record.Type {
+		case RECORD_ENV:
+			if record.Version != FORMAT_VERSION {
+				return replayed, fmt.Errorf("%s:%d: unsupported format version %d (expected %d)", path, line, record.Version, FORMAT_VERSION)
+			}
+			base, err := environment.NewEnvironmentFromSpec(*record.Env)
+			if err != nil {
+				return replayed, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			envs[envKey{trial: record.Trial, agent: record.Agent}] = environment.NewNonStationaryEnv(base, record.Env.Schedule)
+		case RECORD_STEP:
+			env, ok := envs[envKey{trial: record.Step.Trial, agent: record.Step.Agent}]
+			if !ok {
+				return replayed, fmt.Errorf("%s:%d: step of trial %d, agent %d recorded before its environment", path, line, record.Step.Trial, record.Step.Agent)
+			}
+			if err := replayStep(env, *record.Step); err != nil {
+				return replayed, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			if onStep != nil {
+				onStep(env.Environment, *record.Step)
+			}
+			replayed++
+		default:
+			return replayed, fmt.Errorf("%s:%d: unknown record type %q", path, line, record.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return replayed, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return replayed, nil
+}
+
+// 1ステップを再実行し，記録と一致するかを検証
+func replayStep(env *environment.NonStationaryEnv, step Step) error {
+	// エピソードの最初のステップでは環境をリセットする
+	state := env.PositionToState(env.AgentPosition())
+	if step.T == 0 {
+		state = env.Reset()
+	}
+	if state != step.State {
+		return fmt.Errorf("trial %d, episode %d, t %d: state %d differs from the recorded %d", step.Trial, step.Episode, step.T, state, step.State)
+	}
+
+	nextState, reward, terminated, truncated, _ := env.Step(step.Action)
+	if nextState != step.NextState || reward != step.Reward || terminated != step.Terminated || truncated != step.Truncated {
+		return fmt.Errorf("trial %d, episode %d, t %d: replayed transition (next state %d, reward %d, terminated %t, truncated %t) differs from the recorded (next state %d, reward %d, terminated %t, truncated %t)",
+			step.Trial, step.Episode, step.T, nextState, reward, terminated, truncated, step.NextState, step.Reward, step.Terminated, step.Truncated)
+	}
+
+	return nil
+}
@@ -0,0 +1,27 @@
+package utils
+
+// マスターシードと添字の列から独立したシードを導出する (SplitMix64の混合関数を使用)
+// 例: DeriveSeed(master, trial) で試行のシード，DeriveSeed(trialSeed, SEED_AGENT, agentIdx) でエージェントのシードを得る
+// 添字の順序が異なれば異なるシードになるので，試行・エージェント・環境の乱数系列が互いに重ならない
+func DeriveSeed(master int64, keys ...int64) int64 {
+	seed := uint64(master)
+	for _, key := range keys {
+		seed = splitMix64(seed ^ splitMix64(uint64(key)))
+	}
+	return int64(splitMix64(seed))
+}
+
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// DeriveSeedで用途ごとのシードを区別するための添字
+const (
+	SEED_AGENT       = iota + 1 // エージェントの行動選択
+	SEED_ENV                    // 環境の遷移 (滑りなど)
+	SEED_VEC_ENV                // ベクトル化環境のコピー
+	SEED_OBSERVATION            // 部分観測のノイズ
+)
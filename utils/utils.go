@@ -1,32 +1,72 @@
 package utils
 
-import "github.com/tuneinsight/lattigo/v4/bfv"
+import (
+	"math"
 
-const N = 10000
+	"github.com/tuneinsight/lattigo/v4/bfv"
+)
+
+// 暗号化できる整数の範囲は[-N, N]
+// MapIntegerで負の値をTを法とする剰余に写すので，2NはBFVの平文の法Tより小さくなければならない
+// Q値は割引報酬和なので，報酬の絶対値の最大値|r|maxに対して|r|max/(1-γ)まで大きくなる
+// これまでの報酬(1ステップで-11，γ=0.9)では±110になるため，±300まで表現できる範囲とする
+const N = 300000
 const Q_int_coeff = 1000.0 // Q_int = Q_new * Q_int_coeff
 
+// BFVの平文の法 (FAST_BUT_NOT_128_SECURITY.T と同じ値)
+// 2N < T かつ T ≡ 1 (mod 2^(LogN+1)) を満たす素数 (786433 = 3*2^18 + 1)
+// 以前の65537では表現できる範囲が±32.7にとどまり，これまでの報酬のQ値が収まらなかった
+const T = 786433
+
 var (
 	FAST_BUT_NOT_128_SECURITY = bfv.ParametersLiteral{
 		LogN: 4,
 		Q:    []uint64{0x7ffffec001, 0x8000016001}, // 39 + 39 bits
 		P:    []uint64{0x40002001},                 // 30 bits
-		T:    65537,
+		T:    T,
 	}
 )
 
-// [-N, N] -> [0, 2N]
+// [-N, N] -> [0, T) (Tを法とする剰余)
+// 平文の加算と同じ法なので，暗号文同士を足した値もUnmapIntegerで元に戻せる (和の絶対値がT/2未満の場合)
 func MapInteger(x int64) uint64 {
 	// Check if x is negative and map accordingly.
 	if x < 0 {
-		return uint64(x + 2*N)
+		return uint64(x + T)
 	}
 	return uint64(x)
 }
 
-// [0, 2N] -> [-N, N]
+// [0, T) -> (-T/2, T/2]
 func UnmapInteger(x uint64) int {
-	if x > N {
-		return int(x - 2*N)
+	if x > T/2 {
+		return int(x) - T
 	}
 	return int(x)
 }
+
+// Q値をクラウドのQテーブルの固定小数点表現に変換する
+// tablesは暗号文のまま和を取るQテーブルの数 (Double Q学習では2) で，和が[-N, N]に収まるように各Q値を[-N/tables, N/tables]とする
+// 範囲を超えた値はTを法として符号が反転してしまうので，範囲の端に飽和させてclampedをtrueとする
+// 飽和させるとエージェントのQ値とクラウドのQ値が一致しなくなるので，呼び出し側で数えて報告すること
+// (報酬はRewardConfig.Validateで検査しているが，累積型の適格度トレースなどではQ値が範囲を超えることがある)
+func EncodeQ(Qnew float64, tables int) (encoded uint64, clamped bool) {
+	limit := int64(N / tables)
+	Qnew_int := int64(math.Round(Qnew * Q_int_coeff))
+	if Qnew_int > limit {
+		Qnew_int, clamped = limit, true
+	} else if Qnew_int < -limit {
+		Qnew_int, clamped = -limit, true
+	}
+	return MapInteger(Qnew_int), clamped
+}
+
+// 固定小数点表現(Q_int_coeff倍)で暗号化できる実数値の最大の絶対値
+func MaxEncodableValue() float64 {
+	return N / Q_int_coeff
+}
+
+// 実数値vを固定小数点表現に変換したときに[-N, N]に収まるか
+func IsEncodable(v float64) bool {
+	return v >= -MaxEncodableValue() && v <= MaxEncodableValue()
+}